@@ -4,5 +4,7 @@ go 1.14
 
 require (
 	github.com/gocql/gocql v0.0.0-20200926162733-393f0c961220
-	github.com/grafana/grafana-plugin-sdk-go v0.65.0
+	github.com/grafana/grafana-plugin-sdk-go v0.114.0
+	github.com/json-iterator/go v1.1.12 // indirect
+	github.com/modern-go/reflect2 v1.0.2 // indirect
 )
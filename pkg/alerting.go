@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Return a DataResponse for the alert rules
+// (The dataResponse contains one frame per numeric attribute instance with 2 fields : time, value.
+// The value field is labelled with the entity id and type, the attribute and the datasetId)
+func transformToAlerting(qm queryModel, entitiesByte []byte, response backend.DataResponse) backend.DataResponse {
+	var entities []map[string]interface{}
+	err := json.Unmarshal(entitiesByte, &entities)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	now := time.Now().UTC()
+	for _, entity := range entities {
+		entityId, _ := entity["id"].(string)
+
+		for _, k := range attributeNames(entity) {
+			for _, instance := range attributeInstances(entity[k]) {
+				//Booleans are alerted on as 0 and 1, the other values can't be compared to a threshold
+				value := numericValue(attributeValue(instance))
+				if value == nil {
+					continue
+				}
+				datasetId, _ := instance["datasetId"].(string)
+
+				valueField := data.NewField(k, seriesLabels(entity, k, datasetId), []*float64{value})
+				if unit := grafanaUnit(unitCode(instance)); unit != "" {
+					valueField.Config = &data.FieldConfig{Unit: unit}
+				}
+				frameName := entityId + " " + k
+				if datasetId != "" {
+					frameName = frameName + " (" + datasetId + ")"
+				}
+				frame := data.NewFrame(frameName,
+					data.NewField("time", nil, []time.Time{valueTime(instance, now)}),
+					valueField,
+				)
+				response.Frames = append(response.Frames, frame)
+			}
+		}
+	}
+	return response
+}
+
+// Labels of the series of an attribute of an entity, the alert rules tell the series apart with them
+func seriesLabels(entity map[string]interface{}, attribute string, datasetId string) data.Labels {
+	entityId, _ := entity["id"].(string)
+	labels := data.Labels{
+		"id":        entityId,
+		"type":      listString(entity["type"]),
+		"attribute": attribute,
+	}
+	if datasetId != "" {
+		labels["datasetId"] = datasetId
+	}
+	return labels
+}
+
+// Return the time of the value of an attribute instance : when it was observed, or else when it was modified.
+// The values without any of these dates are given the time of the query.
+func valueTime(instance map[string]interface{}, queryTime time.Time) time.Time {
+	if observedAt := parseTime(instance["observedAt"]); observedAt != nil {
+		return *observedAt
+	}
+	if modifiedAt := parseTime(instance["modifiedAt"]); modifiedAt != nil {
+		return *modifiedAt
+	}
+	return queryTime
+}
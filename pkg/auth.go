@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Authentication modes of the datasource
+const (
+	authModeNone              = "none"
+	authModeBearer            = "bearer"
+	authModeBasic             = "basic"
+	authModeApiKey            = "apiKey"
+	authModePassword          = "password"
+	authModeClientCredentials = "clientCredentials"
+)
+
+// Header used to send the API key when no header is configured
+const defaultApiKeyHeader = "X-API-Key"
+
+// authenticator adds the credentials of the datasource to the requests sent to the broker
+type authenticator interface {
+	// Set the credentials on the request
+	authenticate(ctx context.Context, r *http.Request) error
+	// Called when the broker rejected the credentials of the request.
+	// Return true if new credentials will be used by the next authenticate call, so that the request can be retried.
+	invalidate(r *http.Request) bool
+}
+
+// Build the authenticator matching the auth mode of the datasource.
+// Datasources created before the auth mode was introduced use client_credentials when an auth server is configured.
+func newAuthenticator(settings settingsModel, setting backend.DataSourceInstanceSettings, instSetting *instanceSettings) (authenticator, error) {
+	secureData := setting.DecryptedSecureJSONData
+
+	authMode := settings.AuthMode
+	if authMode == "" {
+		authMode = authModeNone
+		if settings.AuthServerUrl != "" {
+			authMode = authModeClientCredentials
+		}
+	}
+
+	switch authMode {
+	case authModeNone:
+		return noAuth{}, nil
+
+	case authModeBearer:
+		if secureData["bearerToken"] == "" {
+			return nil, errors.New("the bearer token is missing")
+		}
+		return &headerAuth{header: "Authorization", value: "Bearer " + secureData["bearerToken"]}, nil
+
+	case authModeBasic:
+		if setting.BasicAuthUser == "" {
+			return nil, errors.New("the basic auth user is missing")
+		}
+		return &basicAuth{username: setting.BasicAuthUser, password: secureData["basicAuthPassword"]}, nil
+
+	case authModeApiKey:
+		if secureData["apiKey"] == "" {
+			return nil, errors.New("the API key is missing")
+		}
+		header := settings.ApiKeyHeader
+		if header == "" {
+			header = defaultApiKeyHeader
+		}
+		return &headerAuth{header: header, value: secureData["apiKey"]}, nil
+
+	case authModePassword:
+		grant := url.Values{}
+		grant.Set("grant_type", "password")
+		grant.Set("username", settings.Username)
+		grant.Set("password", secureData["password"])
+		setClientGrantParams(grant, settings, secureData)
+		return newTokenManager(instSetting, grant), nil
+
+	case authModeClientCredentials:
+		grant := url.Values{}
+		grant.Set("grant_type", "client_credentials")
+		setClientGrantParams(grant, settings, secureData)
+		return newTokenManager(instSetting, grant), nil
+	}
+
+	return nil, fmt.Errorf("unknown auth mode %q", authMode)
+}
+
+// Set the client and the optional scope and audience of an OAuth2 grant
+func setClientGrantParams(grant url.Values, settings settingsModel, secureData map[string]string) {
+	grant.Set("client_id", settings.ClientId)
+	if secureData["clientSecret"] != "" {
+		grant.Set("client_secret", secureData["clientSecret"])
+	}
+	if settings.Scope != "" {
+		grant.Set("scope", settings.Scope)
+	}
+	if settings.Audience != "" {
+		grant.Set("audience", settings.Audience)
+	}
+}
+
+// noAuth sends the requests without credentials, for brokers that are not secured
+type noAuth struct{}
+
+func (noAuth) authenticate(ctx context.Context, r *http.Request) error {
+	return nil
+}
+
+func (noAuth) invalidate(r *http.Request) bool {
+	return false
+}
+
+// headerAuth sends static credentials in a header : a bearer token or an API key
+type headerAuth struct {
+	header string
+	value  string
+}
+
+func (a *headerAuth) authenticate(ctx context.Context, r *http.Request) error {
+	r.Header.Set(a.header, a.value)
+	return nil
+}
+
+func (a *headerAuth) invalidate(r *http.Request) bool {
+	return false
+}
+
+// basicAuth sends the user and password with HTTP basic authentication
+type basicAuth struct {
+	username string
+	password string
+}
+
+func (a *basicAuth) authenticate(ctx context.Context, r *http.Request) error {
+	r.SetBasicAuth(a.username, a.password)
+	return nil
+}
+
+func (a *basicAuth) invalidate(r *http.Request) bool {
+	return false
+}
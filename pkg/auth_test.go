@@ -0,0 +1,53 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestNewAuthenticator(t *testing.T) {
+	tests := []struct {
+		name       string
+		authMode   string
+		basicUser  string
+		secureData map[string]string
+		wantHeader string
+		wantValue  string
+		wantErr    bool
+	}{
+		{name: "no auth", authMode: "none"},
+		{name: "bearer token", authMode: "bearer", secureData: map[string]string{"bearerToken": "secret"}, wantHeader: "Authorization", wantValue: "Bearer secret"},
+		{name: "missing bearer token", authMode: "bearer", wantErr: true},
+		{name: "API key", authMode: "apiKey", secureData: map[string]string{"apiKey": "secret"}, wantHeader: "X-API-Key", wantValue: "secret"},
+		{name: "missing API key", authMode: "apiKey", wantErr: true},
+		{name: "basic auth", authMode: "basic", basicUser: "grafana", secureData: map[string]string{"basicAuthPassword": "secret"}, wantHeader: "Authorization", wantValue: "Basic Z3JhZmFuYTpzZWNyZXQ="},
+		{name: "missing basic auth user", authMode: "basic", secureData: map[string]string{"basicAuthPassword": "secret"}, wantErr: true},
+		{name: "unknown auth mode", authMode: "kerberos", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			jsonSettings, _ := json.Marshal(map[string]interface{}{"authMode": tt.authMode})
+			instance, err := newDataSourceInstance(backend.DataSourceInstanceSettings{
+				JSONData:                jsonSettings,
+				BasicAuthUser:           tt.basicUser,
+				DecryptedSecureJSONData: tt.secureData,
+			})
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newDataSourceInstance() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			r, _ := http.NewRequest(http.MethodGet, "http://broker/ngsi-ld/v1/entities", nil)
+			if err := instance.(*instanceSettings).auth.authenticate(r.Context(), r); err != nil {
+				t.Fatal(err)
+			}
+			if tt.wantHeader != "" && r.Header.Get(tt.wantHeader) != tt.wantValue {
+				t.Errorf("%s header = %q, want %q", tt.wantHeader, r.Header.Get(tt.wantHeader), tt.wantValue)
+			}
+		})
+	}
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Tell if the query asks for a single entity by its id, it is then fetched with its own resource
+// instead of querying the entities.
+func isSingleEntityQuery(qm queryModel) bool {
+	return qm.IdPattern == "" && len(splitList(qm.EntityId)) == 1
+}
+
+// Check the type query of the query model before it is sent to the broker.
+// Several types are separated by commas, or combined with ; (and) and | (or), and parentheses :
+// Building,Room or (Sensor;Device)|Vehicle
+func validateTypeQuery(typeQuery string) error {
+	if strings.TrimSpace(typeQuery) == "" {
+		return nil
+	}
+	return validateExpression("entity type", typeQuery, ",;|", parseTypeName)
+}
+
+// Add the id, idPattern and type parameters selecting the entities to the query parameters sent to the broker
+func setEntitySelectionParams(q url.Values, qm queryModel) {
+	if ids := splitList(qm.EntityId); len(ids) > 0 {
+		q.Set("id", strings.Join(ids, ","))
+	}
+	if qm.IdPattern != "" {
+		q.Set("idPattern", qm.IdPattern)
+	}
+	//The spaces are only there to make the expression readable
+	if typeQuery := strings.ReplaceAll(qm.EntityType, " ", ""); typeQuery != "" {
+		q.Set("type", typeQuery)
+	}
+}
+
+// A type is a short name or an IRI, it ends at the first operator
+func parseTypeName(p *expressionParser) error {
+	start := p.pos
+	for !p.atOperandEnd() && p.query[p.pos] != '(' {
+		p.pos++
+	}
+	if p.pos == start {
+		return fmt.Errorf("a type is expected at position %d", p.pos+1)
+	}
+	return nil
+}
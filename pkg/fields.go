@@ -0,0 +1,294 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Grafana units of the most used UN/CEFACT unit codes, the other codes are displayed as a suffix
+var grafanaUnits = map[string]string{
+	"CEL": "celsius",
+	"FAH": "fahrenheit",
+	"KEL": "kelvin",
+	"P1":  "percent",
+	"MMT": "lengthmm",
+	"MTR": "lengthm",
+	"KMT": "lengthkm",
+	"GRM": "massg",
+	"KGM": "masskg",
+	"WTT": "watt",
+	"KWT": "kwatt",
+	"WHR": "watth",
+	"KWH": "kwatth",
+	"VLT": "volt",
+	"AMP": "amp",
+	"HTZ": "hertz",
+	"PAL": "pressurepa",
+	"A97": "pressurehpa",
+	"BAR": "pressurebar",
+	"MBR": "pressurembar",
+	"SEC": "s",
+	"MIN": "m",
+	"HUR": "h",
+	"MTS": "velocityms",
+	"KMH": "velocitykmh",
+	"LTR": "litre",
+	"MTQ": "m3",
+	//The unit of Grafana is written with the Greek letter mu, not the micro sign
+	"GQ":  "conμgm3",
+	"59":  "ppm",
+	"61":  "conppb",
+	"DD":  "degree",
+	"2N":  "dB",
+	"LUX": "lux",
+	"MTK": "areaM2",
+}
+
+// Kinds of values of a column, a field is built for each kind found in the column
+const (
+	numberKind = iota
+	booleanKind
+	timeKind
+	textKind
+)
+
+// Suffix of the name of the additional fields, when a column mixes several kinds of values
+var kindSuffixes = map[int]string{
+	booleanKind: " (boolean)",
+	timeKind:    " (time)",
+	textKind:    " (text)",
+}
+
+// Values of a column of a frame. The values are kept as sent by the broker,
+// the type of the field is chosen from all the values when the frame is built.
+type valueColumn struct {
+	name      string
+	values    []interface{}
+	unitCodes []string
+}
+
+func newValueColumn(name string) *valueColumn {
+	return &valueColumn{name: name}
+}
+
+// Add a value with its unit code, nil for a missing value
+func (c *valueColumn) append(value interface{}, unitCode string) {
+	c.values = append(c.values, value)
+	c.unitCodes = append(c.unitCodes, unitCode)
+}
+
+// Number of values of the column
+func (c *valueColumn) len() int {
+	return len(c.values)
+}
+
+// Build the fields of the column. Numbers are nullable float64, booleans nullable bool, date-times nullable time
+// and the other values are text. A column mixing several kinds of values gets one field per kind,
+// the first kind found in this order keeps the name of the column.
+func (c *valueColumn) fields() []*data.Field {
+	kinds := make([]int, len(c.values))
+	typedValues := make([]interface{}, len(c.values))
+	var found = map[int]bool{}
+	for i, value := range c.values {
+		kinds[i], typedValues[i] = typedValue(value)
+		if typedValues[i] != nil {
+			found[kinds[i]] = true
+		}
+	}
+	if len(found) == 0 {
+		found[numberKind] = true
+	}
+
+	var fields []*data.Field
+	for _, kind := range []int{numberKind, booleanKind, timeKind, textKind} {
+		if !found[kind] {
+			continue
+		}
+		name := c.name
+		if len(fields) > 0 {
+			name += kindSuffixes[kind]
+		}
+		field := c.kindField(kind, name, kinds, typedValues)
+		if kind == numberKind {
+			if unit := c.commonUnit(kinds); unit != "" {
+				field.Config = &data.FieldConfig{Unit: unit}
+			}
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// Build the field holding the values of a kind, the values of the other kinds are null
+func (c *valueColumn) kindField(kind int, name string, kinds []int, typedValues []interface{}) *data.Field {
+	var field *data.Field
+	switch kind {
+	case numberKind:
+		field = data.NewField(name, nil, make([]*float64, len(typedValues)))
+	case booleanKind:
+		field = data.NewField(name, nil, make([]*bool, len(typedValues)))
+	case timeKind:
+		field = data.NewField(name, nil, make([]*time.Time, len(typedValues)))
+	default:
+		field = data.NewField(name, nil, make([]*string, len(typedValues)))
+	}
+	for i, value := range typedValues {
+		if value == nil || kinds[i] != kind {
+			continue
+		}
+		switch v := value.(type) {
+		case float64:
+			field.Set(i, &v)
+		case bool:
+			field.Set(i, &v)
+		case time.Time:
+			field.Set(i, &v)
+		case string:
+			field.Set(i, &v)
+		}
+	}
+	return field
+}
+
+// Return the Grafana unit of the numbers of the column when they all have the same unit code
+func (c *valueColumn) commonUnit(kinds []int) string {
+	var unitCode string
+	for i, value := range c.values {
+		if value == nil || kinds[i] != numberKind {
+			continue
+		}
+		if c.unitCodes[i] == "" || (unitCode != "" && c.unitCodes[i] != unitCode) {
+			return ""
+		}
+		unitCode = c.unitCodes[i]
+	}
+	return grafanaUnit(unitCode)
+}
+
+// Return the Grafana unit of a UN/CEFACT unit code
+func grafanaUnit(unitCode string) string {
+	if unitCode == "" {
+		return ""
+	}
+	if unit, found := grafanaUnits[unitCode]; found {
+		return unit
+	}
+	return "suffix: " + unitCode
+}
+
+// Return the kind of a value sent by the broker and the value converted to the Go type of this kind
+func typedValue(value interface{}) (int, interface{}) {
+	switch v := value.(type) {
+	case nil:
+		return textKind, nil
+	case float64:
+		return numberKind, v
+	case bool:
+		return booleanKind, v
+	case string:
+		return textKind, v
+	case time.Time:
+		return timeKind, v
+	case map[string]interface{}:
+		//A date-time is sent as a typed JSON-LD value
+		if v["@type"] == "DateTime" {
+			if parsedTime := parseTime(v["@value"]); parsedTime != nil {
+				return timeKind, *parsedTime
+			}
+		}
+	}
+	jsonValue, err := json.Marshal(value)
+	if err != nil {
+		return textKind, fmt.Sprintf("%v", value)
+	}
+	return textKind, string(jsonValue)
+}
+
+// Members holding the value of an attribute instance, depending on the type of the attribute
+var attributeValueMembers = []string{"value", "object", "languageMap", "vocab", "json", "valueList", "objectList"}
+
+// Return the value of an attribute instance : the value of a Property, the object of a Relationship...
+func attributeValue(instance map[string]interface{}) interface{} {
+	for _, member := range attributeValueMembers {
+		if value, found := instance[member]; found {
+			return value
+		}
+	}
+	return nil
+}
+
+// Return the unit code of an attribute instance, or an empty string
+func unitCode(instance map[string]interface{}) string {
+	unitCode, _ := instance["unitCode"].(string)
+	return unitCode
+}
+
+// Return the first instance of an attribute of an entity, the one displayed when the attribute has several instances.
+// Nil if the entity does not have the attribute.
+func firstInstance(entity map[string]interface{}, name string) map[string]interface{} {
+	if instances := attributeInstances(entity[name]); len(instances) > 0 {
+		return instances[0]
+	}
+	return nil
+}
+
+// Return the value and the unit code of the first instance of an attribute of an entity, nil if the entity does not have it
+func firstInstanceValue(entity map[string]interface{}, name string) (interface{}, string) {
+	instance := firstInstance(entity, name)
+	if instance == nil {
+		return nil, ""
+	}
+	return attributeValue(instance), unitCode(instance)
+}
+
+// Parse a date-time sent by the broker, nil if it is not a valid date-time
+func parseTime(value interface{}) *time.Time {
+	timeString, ok := value.(string)
+	if !ok {
+		return nil
+	}
+	parsedTime, err := time.Parse(time.RFC3339Nano, timeString)
+	if err != nil {
+		return nil
+	}
+	return &parsedTime
+}
+
+// Return the names of the attributes of an entity in alphabetical order
+func attributeNames(entity map[string]interface{}) []string {
+	var names []string
+	for name, member := range entity {
+		if !entityIdentityMembers[name] && isAttribute(member) {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Return the elements of a member that is a single string or a list of strings (scope, type) separated by commas
+func listString(member interface{}) string {
+	return strings.Join(stringList(member), ", ")
+}
+
+// Return the elements of a member that is a single string or a list of strings (scope, type)
+func stringList(member interface{}) []string {
+	switch v := member.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		var elements []string
+		for _, element := range v {
+			if text, ok := element.(string); ok {
+				elements = append(elements, text)
+			}
+		}
+		return elements
+	}
+	return nil
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestFirstInstanceValue(t *testing.T) {
+	tests := []struct {
+		name      string
+		entity    string
+		wantValue interface{}
+		wantUnit  string
+	}{
+		{name: "single instance", entity: `{"temperature":{"type":"Property","value":20,"unitCode":"CEL"}}`, wantValue: float64(20), wantUnit: "CEL"},
+		{name: "several instances", entity: `{"temperature":[{"type":"Property","value":20,"datasetId":"urn:a"},{"type":"Property","value":21}]}`, wantValue: float64(20)},
+		{name: "relationship", entity: `{"temperature":{"type":"Relationship","object":"urn:b"}}`, wantValue: "urn:b"},
+		{name: "missing attribute", entity: `{"humidity":{"type":"Property","value":50}}`},
+		{name: "not an attribute", entity: `{"temperature":"20"}`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var entity map[string]interface{}
+			if err := json.Unmarshal([]byte(tt.entity), &entity); err != nil {
+				t.Fatal(err)
+			}
+			value, unit := firstInstanceValue(entity, "temperature")
+			if value != tt.wantValue || unit != tt.wantUnit {
+				t.Errorf("firstInstanceValue() = %v, %q, want %v, %q", value, unit, tt.wantValue, tt.wantUnit)
+			}
+		})
+	}
+}
+
+func TestGrafanaUnit(t *testing.T) {
+	tests := []struct {
+		unitCode string
+		want     string
+	}{
+		{unitCode: "", want: ""},
+		{unitCode: "CEL", want: "celsius"},
+		{unitCode: "2N", want: "dB"},
+		{unitCode: "DB", want: "suffix: DB"},
+		{unitCode: "59", want: "ppm"},
+		{unitCode: "61", want: "conppb"},
+		{unitCode: "GQ", want: "conμgm3"},
+		{unitCode: "XYZ", want: "suffix: XYZ"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.unitCode, func(t *testing.T) {
+			if unit := grafanaUnit(tt.unitCode); unit != tt.want {
+				t.Errorf("grafanaUnit(%q) = %q, want %q", tt.unitCode, unit, tt.want)
+			}
+		})
+	}
+}
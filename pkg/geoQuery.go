@@ -0,0 +1,203 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Geo relationships defined by NGSI-LD
+var geoRels = map[string]bool{
+	"near":       true,
+	"within":     true,
+	"contains":   true,
+	"intersects": true,
+	"disjoint":   true,
+	"overlaps":   true,
+	"equals":     true,
+}
+
+// A position is [longitude, latitude] with an optional altitude
+type geoPosition []float64
+
+// Check the geo-query of the query model before it is sent to the broker, and return the georel without spaces
+// and the coordinates in their compact JSON form. No geo-query is done when the georel, geometry and coordinates are all empty.
+func validateGeoQuery(qm queryModel) (string, string, error) {
+	if qm.Georel == "" && qm.Geometry == "" && qm.Coordinates == "" {
+		return "", "", nil
+	}
+	if qm.Georel == "" || qm.Geometry == "" || qm.Coordinates == "" {
+		return "", "", errors.New("a geo-query needs a georel, a geometry and coordinates")
+	}
+
+	georel, err := validateGeorel(qm.Georel, qm.Geometry)
+	if err != nil {
+		return "", "", err
+	}
+
+	//A point chosen by a dashboard variable can be written without brackets : 5.72,45.18
+	coordinates := strings.TrimSpace(qm.Coordinates)
+	if qm.Geometry == "Point" && !strings.HasPrefix(coordinates, "[") {
+		coordinates = "[" + coordinates + "]"
+	}
+
+	var parsedCoordinates interface{}
+	if err := json.Unmarshal([]byte(coordinates), &parsedCoordinates); err != nil {
+		return "", "", fmt.Errorf("invalid coordinates, a JSON array is expected: %w", err)
+	}
+	if err := validateCoordinates(qm.Geometry, parsedCoordinates); err != nil {
+		return "", "", fmt.Errorf("invalid coordinates for a %s: %w", qm.Geometry, err)
+	}
+
+	compactCoordinates, err := json.Marshal(parsedCoordinates)
+	return georel, string(compactCoordinates), err
+}
+
+// Add the geo-query parameters to the query parameters sent to the broker,
+// the query model must have been checked by validateGeoQuery
+func setGeoQueryParams(q url.Values, qm queryModel) {
+	if qm.Coordinates == "" {
+		return
+	}
+	q.Set("georel", qm.Georel)
+	q.Set("geometry", qm.Geometry)
+	q.Set("coordinates", qm.Coordinates)
+	if qm.Geoproperty != "" {
+		q.Set("geoproperty", qm.Geoproperty)
+	}
+}
+
+// Check the georel : near;maxDistance==2000, near;minDistance==10, within, contains...
+// Return the georel without the spaces around its parts : near ; maxDistance == 2000 is understood too.
+func validateGeorel(georel string, geometry string) (string, error) {
+	parts := strings.Split(georel, ";")
+	for i := range parts {
+		parts[i] = strings.TrimSpace(parts[i])
+	}
+	if !geoRels[parts[0]] {
+		return "", fmt.Errorf("unknown georel %q, allowed georels are near, within, contains, intersects, disjoint, overlaps and equals", parts[0])
+	}
+
+	if parts[0] != "near" {
+		if len(parts) > 1 {
+			return "", fmt.Errorf("georel %q does not accept a distance", parts[0])
+		}
+		return parts[0], nil
+	}
+
+	if geometry != "Point" {
+		return "", errors.New("georel near can only be used with a Point geometry")
+	}
+	if len(parts) != 2 {
+		return "", errors.New("georel near needs a distance : near;maxDistance==<meters> or near;minDistance==<meters>")
+	}
+	distance := strings.SplitN(parts[1], "==", 2)
+	for i := range distance {
+		distance[i] = strings.TrimSpace(distance[i])
+	}
+	if len(distance) != 2 || (distance[0] != "maxDistance" && distance[0] != "minDistance") {
+		return "", fmt.Errorf("invalid distance %q, maxDistance==<meters> or minDistance==<meters> is expected", parts[1])
+	}
+	meters, err := strconv.ParseFloat(distance[1], 64)
+	if err != nil || meters < 0 {
+		return "", fmt.Errorf("invalid distance %q, a positive number of meters is expected", distance[1])
+	}
+	return "near;" + distance[0] + "==" + distance[1], nil
+}
+
+// Check that the coordinates have the structure required by the GeoJSON geometry
+func validateCoordinates(geometry string, coordinates interface{}) error {
+	switch geometry {
+	case "Point":
+		_, err := toPosition(coordinates)
+		return err
+	case "MultiPoint":
+		_, err := toPositions(coordinates, 1)
+		return err
+	case "LineString":
+		_, err := toPositions(coordinates, 2)
+		return err
+	case "MultiLineString":
+		return forEachCoordinates(coordinates, func(line interface{}) error {
+			_, err := toPositions(line, 2)
+			return err
+		})
+	case "Polygon":
+		return validatePolygon(coordinates)
+	case "MultiPolygon":
+		return forEachCoordinates(coordinates, validatePolygon)
+	}
+	return fmt.Errorf("unknown geometry %q", geometry)
+}
+
+// A polygon is a list of closed linear rings of at least 4 positions
+func validatePolygon(coordinates interface{}) error {
+	return forEachCoordinates(coordinates, func(ring interface{}) error {
+		positions, err := toPositions(ring, 4)
+		if err != nil {
+			return err
+		}
+		first, last := positions[0], positions[len(positions)-1]
+		if len(first) != len(last) || first[0] != last[0] || first[1] != last[1] {
+			return errors.New("the first and last positions of a polygon ring must be the same")
+		}
+		return nil
+	})
+}
+
+// Call validate on each element of a non empty list of coordinates
+func forEachCoordinates(coordinates interface{}, validate func(interface{}) error) error {
+	list, ok := coordinates.([]interface{})
+	if !ok || len(list) == 0 {
+		return errors.New("a non empty list is expected")
+	}
+	for _, element := range list {
+		if err := validate(element); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Convert a list of at least min positions
+func toPositions(coordinates interface{}, min int) ([]geoPosition, error) {
+	list, ok := coordinates.([]interface{})
+	if !ok || len(list) < min {
+		return nil, fmt.Errorf("a list of at least %d positions is expected", min)
+	}
+	positions := make([]geoPosition, len(list))
+	for i, element := range list {
+		position, err := toPosition(element)
+		if err != nil {
+			return nil, err
+		}
+		positions[i] = position
+	}
+	return positions, nil
+}
+
+// Convert a position [longitude, latitude] or [longitude, latitude, altitude]
+func toPosition(coordinates interface{}) (geoPosition, error) {
+	list, ok := coordinates.([]interface{})
+	if !ok || len(list) < 2 || len(list) > 3 {
+		return nil, errors.New("a position [longitude, latitude] is expected")
+	}
+	position := make(geoPosition, len(list))
+	for i, element := range list {
+		value, ok := element.(float64)
+		if !ok {
+			return nil, errors.New("the values of a position must be numbers")
+		}
+		position[i] = value
+	}
+	if position[0] < -180 || position[0] > 180 {
+		return nil, fmt.Errorf("longitude %v is out of range [-180, 180]", position[0])
+	}
+	if position[1] < -90 || position[1] > 90 {
+		return nil, fmt.Errorf("latitude %v is out of range [-90, 90]", position[1])
+	}
+	return position, nil
+}
@@ -0,0 +1,76 @@
+package main
+
+import "testing"
+
+func TestValidateGeoQuery(t *testing.T) {
+	tests := []struct {
+		name            string
+		qm              queryModel
+		wantGeorel      string
+		wantCoordinates string
+		wantErr         bool
+	}{
+		{name: "no geo-query", qm: queryModel{}},
+		{
+			name:            "near a point",
+			qm:              queryModel{Georel: "near;maxDistance==2000", Geometry: "Point", Coordinates: "[5.72, 45.18]"},
+			wantGeorel:      "near;maxDistance==2000",
+			wantCoordinates: "[5.72,45.18]",
+		},
+		{
+			name:            "spaces around the distance",
+			qm:              queryModel{Georel: "near ; minDistance == 10 ", Geometry: "Point", Coordinates: "[5.72,45.18]"},
+			wantGeorel:      "near;minDistance==10",
+			wantCoordinates: "[5.72,45.18]",
+		},
+		{
+			name:            "space before the distance value",
+			qm:              queryModel{Georel: "near;maxDistance== 2000", Geometry: "Point", Coordinates: "[5.72,45.18]"},
+			wantGeorel:      "near;maxDistance==2000",
+			wantCoordinates: "[5.72,45.18]",
+		},
+		{
+			name:            "point without brackets",
+			qm:              queryModel{Georel: "near;maxDistance==2000", Geometry: "Point", Coordinates: " 5.72,45.18 "},
+			wantGeorel:      "near;maxDistance==2000",
+			wantCoordinates: "[5.72,45.18]",
+		},
+		{
+			name:            "within a polygon",
+			qm:              queryModel{Georel: "within", Geometry: "Polygon", Coordinates: "[[[0,0],[0,1],[1,1],[0,0]]]"},
+			wantGeorel:      "within",
+			wantCoordinates: "[[[0,0],[0,1],[1,1],[0,0]]]",
+		},
+		{
+			name:            "intersects a multi line string",
+			qm:              queryModel{Georel: "intersects", Geometry: "MultiLineString", Coordinates: "[[[0,0],[1,1]],[[2,2],[3,3]]]"},
+			wantGeorel:      "intersects",
+			wantCoordinates: "[[[0,0],[1,1]],[[2,2],[3,3]]]",
+		},
+		{name: "missing coordinates", qm: queryModel{Georel: "within", Geometry: "Polygon"}, wantErr: true},
+		{name: "unknown georel", qm: queryModel{Georel: "inside", Geometry: "Point", Coordinates: "[0,0]"}, wantErr: true},
+		{name: "distance of another georel", qm: queryModel{Georel: "within;maxDistance==10", Geometry: "Point", Coordinates: "[0,0]"}, wantErr: true},
+		{name: "near without distance", qm: queryModel{Georel: "near", Geometry: "Point", Coordinates: "[0,0]"}, wantErr: true},
+		{name: "near a polygon", qm: queryModel{Georel: "near;maxDistance==10", Geometry: "Polygon", Coordinates: "[[[0,0],[0,1],[1,1],[0,0]]]"}, wantErr: true},
+		{name: "unknown distance", qm: queryModel{Georel: "near;distance==10", Geometry: "Point", Coordinates: "[0,0]"}, wantErr: true},
+		{name: "negative distance", qm: queryModel{Georel: "near;maxDistance==-10", Geometry: "Point", Coordinates: "[0,0]"}, wantErr: true},
+		{name: "distance not a number", qm: queryModel{Georel: "near;maxDistance==far", Geometry: "Point", Coordinates: "[0,0]"}, wantErr: true},
+		{name: "coordinates not JSON", qm: queryModel{Georel: "within", Geometry: "Polygon", Coordinates: "[[0,0"}, wantErr: true},
+		{name: "latitude out of range", qm: queryModel{Georel: "near;maxDistance==10", Geometry: "Point", Coordinates: "[5.72,95]"}, wantErr: true},
+		{name: "position with one value", qm: queryModel{Georel: "near;maxDistance==10", Geometry: "Point", Coordinates: "[5.72]"}, wantErr: true},
+		{name: "line string of one position", qm: queryModel{Georel: "intersects", Geometry: "LineString", Coordinates: "[[0,0]]"}, wantErr: true},
+		{name: "polygon not closed", qm: queryModel{Georel: "within", Geometry: "Polygon", Coordinates: "[[[0,0],[0,1],[1,1],[1,0]]]"}, wantErr: true},
+		{name: "unknown geometry", qm: queryModel{Georel: "within", Geometry: "Circle", Coordinates: "[0,0]"}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			georel, coordinates, err := validateGeoQuery(tt.qm)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("validateGeoQuery() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if georel != tt.wantGeorel || coordinates != tt.wantCoordinates {
+				t.Errorf("validateGeoQuery() = %q, %q, want %q, %q", georel, coordinates, tt.wantGeorel, tt.wantCoordinates)
+			}
+		})
+	}
+}
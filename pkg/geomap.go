@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Return a DataResponse to display the geometries of the entities in the Geomap panel
+// (The dataResponse contains a frame with one row per located entity : id, type, geometry in Well-Known Text
+// and in GeoJSON, latitude and longitude of its center, and the value of the map metric)
+func transformToGeomap(qm queryModel, entitiesByte []byte, response backend.DataResponse) backend.DataResponse {
+	var geoproperty = mapGeoproperty(qm)
+	var mapMetric = qm.MapMetric
+
+	var entities []map[string]interface{}
+	err := json.Unmarshal(entitiesByte, &entities)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	//Store each value on a slice
+	var ids []string
+	var types []string
+	var geometries []string
+	var geoJSONs []string
+	var latitudes []float64
+	var longitudes []float64
+	var metrics = newValueColumn(mapMetric)
+
+	// Range over entities
+	for _, entity := range entities {
+		geometry, err := entityGeometry(entity, geoproperty)
+		if err != nil {
+			log.DefaultLogger.Warn("invalid entity geometry", "id", entity["id"], "geoproperty", geoproperty, "err", err)
+			continue
+		}
+		// We can't display an entity without geometry
+		if geometry == nil {
+			continue
+		}
+
+		entityId, _ := entity["id"].(string)
+		latitude, longitude := geometry.center()
+		ids = append(ids, entityId)
+		types = append(types, listString(entity["type"]))
+		geometries = append(geometries, geometry.wkt())
+		geoJSONs = append(geoJSONs, geometry.geoJSON())
+		latitudes = append(latitudes, latitude)
+		longitudes = append(longitudes, longitude)
+
+		metrics.append(firstInstanceValue(entity, mapMetric))
+	}
+
+	frame := data.NewFrame(qm.EntityId,
+		data.NewField("id", nil, ids),
+		data.NewField("type", nil, types),
+		data.NewField("geometry", nil, geometries),
+		data.NewField("geojson", nil, geoJSONs),
+		data.NewField("latitude", nil, latitudes),
+		data.NewField("longitude", nil, longitudes),
+	)
+	if mapMetric != "" {
+		frame.Fields = append(frame.Fields, metrics.fields()...)
+	}
+
+	response.Frames = append(response.Frames, frame)
+	return response
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// GeoProperty displayed on the maps when the query does not select one
+const defaultGeoproperty = "location"
+
+// A GeoJSON geometry, as the value of a GeoProperty
+type geoJSONGeometry struct {
+	Type        string            `json:"type"`
+	Coordinates interface{}       `json:"coordinates,omitempty"`
+	Geometries  []geoJSONGeometry `json:"geometries,omitempty"`
+}
+
+// Return the GeoProperty displayed on the maps : the one chosen for display, the geoproperty of the geo-query or the location
+func mapGeoproperty(qm queryModel) string {
+	if qm.DisplayGeoproperty != "" {
+		return qm.DisplayGeoproperty
+	}
+	if qm.Geoproperty != "" {
+		return qm.Geoproperty
+	}
+	return defaultGeoproperty
+}
+
+// Return the geometry of a GeoProperty of an entity, nil if the entity does not have this GeoProperty.
+// When the GeoProperty has several instances, the first one is used.
+func entityGeometry(entity map[string]interface{}, geoproperty string) (*geoJSONGeometry, error) {
+	value, _ := firstInstanceValue(entity, geoproperty)
+	if value == nil {
+		return nil, nil
+	}
+
+	var geometry geoJSONGeometry
+	var err error
+	//Some brokers send the geometry serialized in a string
+	if stringValue, ok := value.(string); ok {
+		err = json.Unmarshal([]byte(stringValue), &geometry)
+	} else {
+		var jsonValue []byte
+		jsonValue, err = json.Marshal(value)
+		if err == nil {
+			err = json.Unmarshal(jsonValue, &geometry)
+		}
+	}
+	if err != nil {
+		return nil, errors.New("a GeoJSON geometry is expected")
+	}
+	if err := geometry.validate(); err != nil {
+		return nil, err
+	}
+	return &geometry, nil
+}
+
+// Check the structure of the coordinates of the geometry
+func (g *geoJSONGeometry) validate() error {
+	if g.Type != "GeometryCollection" {
+		return validateCoordinates(g.Type, g.Coordinates)
+	}
+	if len(g.Geometries) == 0 {
+		return errors.New("a geometry collection can't be empty")
+	}
+	for i := range g.Geometries {
+		if err := g.Geometries[i].validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Return all the positions of the geometry
+func (g *geoJSONGeometry) positions() []geoPosition {
+	if g.Type == "GeometryCollection" {
+		var positions []geoPosition
+		for i := range g.Geometries {
+			positions = append(positions, g.Geometries[i].positions()...)
+		}
+		return positions
+	}
+	return collectPositions(g.Coordinates)
+}
+
+// Return the center of the bounding box of the geometry as latitude and longitude,
+// it locates the geometry on the maps that only display points
+func (g *geoJSONGeometry) center() (float64, float64) {
+	minLon, minLat := math.Inf(1), math.Inf(1)
+	maxLon, maxLat := math.Inf(-1), math.Inf(-1)
+	for _, position := range g.positions() {
+		minLon, maxLon = math.Min(minLon, position[0]), math.Max(maxLon, position[0])
+		minLat, maxLat = math.Min(minLat, position[1]), math.Max(maxLat, position[1])
+	}
+	return (minLat + maxLat) / 2, (minLon + maxLon) / 2
+}
+
+// Return the geometry in Well-Known Text, the representation the Geomap panel turns into shapes
+func (g *geoJSONGeometry) wkt() string {
+	if g.Type == "GeometryCollection" {
+		geometries := make([]string, len(g.Geometries))
+		for i := range g.Geometries {
+			geometries[i] = g.Geometries[i].wkt()
+		}
+		return "GEOMETRYCOLLECTION (" + strings.Join(geometries, ", ") + ")"
+	}
+	if g.Type == "Point" {
+		return "POINT (" + wktCoordinates(g.Coordinates) + ")"
+	}
+	return strings.ToUpper(g.Type) + " " + wktCoordinates(g.Coordinates)
+}
+
+// Return the GeoJSON of the geometry
+func (g *geoJSONGeometry) geoJSON() string {
+	jsonGeometry, _ := json.Marshal(g)
+	return string(jsonGeometry)
+}
+
+// Write the coordinates in Well-Known Text : a position is "longitude latitude", a list is written in parentheses
+func wktCoordinates(coordinates interface{}) string {
+	list, _ := coordinates.([]interface{})
+	if position, err := toPosition(coordinates); err == nil {
+		return strconv.FormatFloat(position[0], 'f', -1, 64) + " " + strconv.FormatFloat(position[1], 'f', -1, 64)
+	}
+	elements := make([]string, len(list))
+	for i, element := range list {
+		elements[i] = wktCoordinates(element)
+	}
+	return "(" + strings.Join(elements, ", ") + ")"
+}
+
+// Return the positions found at any depth of the coordinates
+func collectPositions(coordinates interface{}) []geoPosition {
+	if position, err := toPosition(coordinates); err == nil {
+		return []geoPosition{position}
+	}
+	list, _ := coordinates.([]interface{})
+	var positions []geoPosition
+	for _, element := range list {
+		positions = append(positions, collectPositions(element)...)
+	}
+	return positions
+}
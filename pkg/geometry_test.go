@@ -0,0 +1,46 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestMapGeoproperty(t *testing.T) {
+	tests := []struct {
+		name          string
+		qm            queryModel
+		wantDisplayed string
+		wantFiltered  string
+	}{
+		{name: "default", qm: queryModel{}, wantDisplayed: "location"},
+		{
+			name:          "geoproperty of the geo-query",
+			qm:            queryModel{Georel: "within", Geometry: "Point", Coordinates: "[5,45]", Geoproperty: "area"},
+			wantDisplayed: "area",
+			wantFiltered:  "area",
+		},
+		{
+			name:          "filter on a geoproperty and display another",
+			qm:            queryModel{Georel: "within", Geometry: "Point", Coordinates: "[5,45]", Geoproperty: "location", DisplayGeoproperty: "area"},
+			wantDisplayed: "area",
+			wantFiltered:  "location",
+		},
+		{
+			name:          "displayed geoproperty without geo-query",
+			qm:            queryModel{DisplayGeoproperty: "area"},
+			wantDisplayed: "area",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if displayed := mapGeoproperty(tt.qm); displayed != tt.wantDisplayed {
+				t.Errorf("mapGeoproperty() = %q, want %q", displayed, tt.wantDisplayed)
+			}
+			q := url.Values{}
+			setGeoQueryParams(q, tt.qm)
+			if filtered := q.Get("geoproperty"); filtered != tt.wantFiltered {
+				t.Errorf("geoproperty of the geo-query = %q, want %q", filtered, tt.wantFiltered)
+			}
+		})
+	}
+}
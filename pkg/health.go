@@ -0,0 +1,114 @@
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+// Check the datasource settings : the credentials must be obtained (from the auth server for OAuth2),
+// and the broker must answer the list of entity types like an NGSI-LD API does.
+func checkHealth(ctx context.Context, instSetting *instanceSettings) *backend.CheckHealthResult {
+	if instSetting.contextBrokerUrl == "" {
+		return healthError("The NGSI-LD API URL is missing")
+	}
+
+	u, err := url.ParseRequestURI(instSetting.contextBrokerUrl + "/ngsi-ld/v1/types")
+	if err != nil {
+		return healthError("Invalid NGSI-LD API URL : " + err.Error())
+	}
+
+	r, err := newBrokerRequest(ctx, u.String(), "", instSetting.tenant)
+	if err != nil {
+		return healthError("Invalid NGSI-LD API URL : " + err.Error())
+	}
+	r.Header.Set("Accept", "application/json")
+
+	//Authenticate first, so that an auth failure is not reported as a broker failure
+	if err := instSetting.auth.authenticate(ctx, r); err != nil {
+		return healthError("Authentication failed : " + describeConnectionError(err))
+	}
+
+	resp, err := doAuthenticatedRequest(r, instSetting)
+	if err != nil {
+		var authErr *authError
+		if errors.As(err, &authErr) {
+			return healthError("Authentication failed : " + describeConnectionError(authErr.err))
+		}
+		return healthError("Unable to reach the context broker : " + describeConnectionError(err))
+	}
+	body, err := readBrokerResponse(resp)
+	if err != nil {
+		var brokerErr *brokerError
+		if !errors.As(err, &brokerErr) {
+			return healthError("Unable to read the context broker response : " + err.Error())
+		}
+		switch {
+		case brokerErr.statusCode == http.StatusUnauthorized || brokerErr.statusCode == http.StatusForbidden:
+			return healthError(fmt.Sprintf("The context broker rejected the credentials (%s), check the authentication settings and the permissions", brokerErr.status))
+		case brokerErr.problem.Title != "":
+			return healthError(fmt.Sprintf("The context broker answered %s : %s", brokerErr.status, problemMessage(brokerErr.problem)))
+		}
+		return healthError(fmt.Sprintf("The context broker answered %s, check that the URL is the one of an NGSI-LD API", brokerErr.status))
+	}
+
+	//An NGSI-LD broker answers an EntityTypeList, or a list of entity types
+	var typeList map[string]interface{}
+	var types []interface{}
+	isTypeList := json.Unmarshal(body, &typeList) == nil && typeList["typeList"] != nil
+	if !isTypeList && json.Unmarshal(body, &types) != nil {
+		return healthError("The context broker response is not an NGSI-LD response, check that the URL is the one of an NGSI-LD API")
+	}
+
+	return &backend.CheckHealthResult{
+		Status:  backend.HealthStatusOk,
+		Message: "Data source is working !",
+	}
+}
+
+func healthError(message string) *backend.CheckHealthResult {
+	return &backend.CheckHealthResult{
+		Status:  backend.HealthStatusError,
+		Message: message,
+	}
+}
+
+// Error of net/http when an https URL points to a server answering in plain HTTP,
+// it replaces the TLS error of the handshake
+const plainHTTPServerError = "server gave HTTP response to HTTPS client"
+
+// Return a message telling if the connection failed because of TLS, of the network, or of the server response
+func describeConnectionError(err error) string {
+	var unknownAuthorityErr x509.UnknownAuthorityError
+	var hostnameErr x509.HostnameError
+	var certificateInvalidErr x509.CertificateInvalidError
+	var netErr net.Error
+	var opErr *net.OpError
+	var dnsErr *net.DNSError
+
+	switch {
+	case errors.As(err, &unknownAuthorityErr):
+		return "TLS error, the server certificate is signed by an unknown authority : " + err.Error()
+	case errors.As(err, &hostnameErr):
+		return "TLS error, the server certificate does not match the host name : " + err.Error()
+	case errors.As(err, &certificateInvalidErr):
+		return "TLS error, the server certificate is invalid : " + err.Error()
+	case strings.Contains(err.Error(), plainHTTPServerError):
+		return "TLS error, the server does not use HTTPS, check the scheme of the URL : " + err.Error()
+	case strings.Contains(err.Error(), "tls:") || strings.Contains(err.Error(), "x509:"):
+		return "TLS error : " + err.Error()
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return "the server did not answer in time : " + err.Error()
+	case errors.As(err, &opErr) || errors.As(err, &dnsErr):
+		return "the server is unreachable : " + err.Error()
+	}
+	return err.Error()
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestCheckHealthConnectionErrors(t *testing.T) {
+	typesHandler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"id":"urn:types","type":"EntityTypeList","typeList":["Sensor"]}`))
+	})
+	plainBroker := httptest.NewServer(typesHandler)
+	defer plainBroker.Close()
+	tlsBroker := httptest.NewTLSServer(typesHandler)
+	defer tlsBroker.Close()
+	closed := httptest.NewServer(typesHandler)
+	closed.Close()
+
+	tests := []struct {
+		name        string
+		brokerUrl   string
+		wantStatus  backend.HealthStatus
+		wantMessage string
+		notMessage  string
+	}{
+		{name: "NGSI-LD broker", brokerUrl: plainBroker.URL, wantStatus: backend.HealthStatusOk, wantMessage: "working"},
+		{name: "https URL of a plain HTTP broker", brokerUrl: strings.Replace(plainBroker.URL, "http:", "https:", 1), wantStatus: backend.HealthStatusError, wantMessage: "TLS error, the server does not use HTTPS"},
+		{name: "certificate of an unknown authority", brokerUrl: tlsBroker.URL, wantStatus: backend.HealthStatusError, wantMessage: "TLS error, the server certificate is signed by an unknown authority"},
+		{name: "no server listening", brokerUrl: closed.URL, wantStatus: backend.HealthStatusError, wantMessage: "the server is unreachable"},
+		{name: "unsupported scheme", brokerUrl: strings.Replace(plainBroker.URL, "http:", "ftp:", 1), wantStatus: backend.HealthStatusError, wantMessage: "unsupported protocol scheme", notMessage: "unreachable"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instSetting := newTestInstance(t, map[string]interface{}{"contextBrokerUrl": tt.brokerUrl})
+			result := checkHealth(context.Background(), instSetting)
+			if result.Status != tt.wantStatus || !strings.Contains(result.Message, tt.wantMessage) ||
+				(tt.notMessage != "" && strings.Contains(result.Message, tt.notMessage)) {
+				t.Errorf("checkHealth() = %v %q, want %v with %q", result.Status, result.Message, tt.wantStatus, tt.wantMessage)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Build an HTTP client shared by all the queries of a datasource instance, one is used for the broker and one for the auth server.
+// Connections are kept open and reused between queries,
+// and the responses are transparently decompressed when the server sends them with gzip.
+func newHTTPClient(settings settingsModel, tlsConfig *tls.Config, timeout time.Duration) *http.Client {
+	transport := &http.Transport{
+		Proxy: http.ProxyFromEnvironment,
+		DialContext: (&net.Dialer{
+			Timeout:   30 * time.Second,
+			KeepAlive: 30 * time.Second,
+		}).DialContext,
+		TLSClientConfig:       tlsConfig,
+		ForceAttemptHTTP2:     true,
+		MaxIdleConns:          100,
+		MaxIdleConnsPerHost:   settings.MaxConcurrentQueries + 2,
+		IdleConnTimeout:       90 * time.Second,
+		TLSHandshakeTimeout:   10 * time.Second,
+		ExpectContinueTimeout: 1 * time.Second,
+		DisableCompression:    false,
+	}
+
+	return &http.Client{
+		Transport: transport,
+		Timeout:   timeout,
+	}
+}
+
+// Build the TLS configuration of the broker from the datasource settings :
+// custom CA bundle, client certificate for mutual TLS, server name and certificate verification.
+func newTLSConfig(settings settingsModel, secureData map[string]string) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: settings.TlsSkipVerify,
+		ServerName:         settings.ServerName,
+	}
+
+	if settings.TlsAuthWithCACert {
+		caCert := secureData["tlsCACert"]
+		if caCert == "" {
+			return nil, errors.New("TLS CA certificate is missing")
+		}
+		caPool := x509.NewCertPool()
+		if !caPool.AppendCertsFromPEM([]byte(caCert)) {
+			return nil, errors.New("unable to parse the TLS CA certificate, a PEM encoded certificate is expected")
+		}
+		tlsConfig.RootCAs = caPool
+	}
+
+	if settings.TlsAuth {
+		cert, err := tls.X509KeyPair([]byte(secureData["tlsClientCert"]), []byte(secureData["tlsClientKey"]))
+		if err != nil {
+			return nil, fmt.Errorf("invalid TLS client certificate or key: %w", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+
+	return tlsConfig, nil
+}
+
+// Build the TLS configuration of the auth server. It is usually another host than the broker, with a public certificate :
+// the CA bundle, client certificate and server name of the broker are not used, only the certificate verification setting.
+func newAuthServerTLSConfig(settings settingsModel) *tls.Config {
+	return &tls.Config{
+		InsecureSkipVerify: settings.TlsSkipVerify,
+	}
+}
@@ -0,0 +1,44 @@
+package main
+
+import (
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestBrokerTLSSettingsOnlyApplyToTheBroker(t *testing.T) {
+	broker := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer broker.Close()
+	caCert := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: broker.Certificate().Raw})
+
+	jsonSettings, _ := json.Marshal(map[string]interface{}{
+		"contextBrokerUrl":  broker.URL,
+		"tlsAuthWithCACert": true,
+		"serverName":        "example.com",
+	})
+	instance, err := newDataSourceInstance(backend.DataSourceInstanceSettings{
+		JSONData:                jsonSettings,
+		DecryptedSecureJSONData: map[string]string{"tlsCACert": string(caCert)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	instSetting := instance.(*instanceSettings)
+
+	//The broker is trusted with the CA of the datasource and the server name of its certificate
+	resp, err := instSetting.httpClient.Get(broker.URL)
+	if err != nil {
+		t.Fatalf("broker request failed: %v", err)
+	}
+	resp.Body.Close()
+
+	//The auth server is verified with the system roots and its own host name
+	authTLS := instSetting.authHttpClient.Transport.(*http.Transport).TLSClientConfig
+	if authTLS.RootCAs != nil || authTLS.ServerName != "" || len(authTLS.Certificates) != 0 {
+		t.Errorf("auth server TLS settings = %+v, the broker settings must not apply", authTLS)
+	}
+}
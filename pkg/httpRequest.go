@@ -1,123 +1,442 @@
 package main
 
 import (
+	"bytes"
+	"context"
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
+	"io/ioutil"
 	"net/http"
 	"net/url"
+	"regexp"
 	"strconv"
 	"strings"
+	"time"
 
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
 	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
 )
 
-func getToken(instSetting *instanceSettings) string {
+// Ask a token to the auth server, data contains the grant parameters
+func getToken(ctx context.Context, instSetting *instanceSettings, data url.Values) (Token, error) {
 	authServerUrl := instSetting.authServerUrl
 	resource := instSetting.resource
-	data := url.Values{}
-	data.Set("client_id", instSetting.clientId)
-	data.Set("client_secret", instSetting.clientSecret)
-	data.Set("grant_type", "client_credentials")
 
-	uri, _ := url.ParseRequestURI(authServerUrl)
+	uri, err := url.ParseRequestURI(authServerUrl)
+	if err != nil {
+		return Token{}, fmt.Errorf("invalid auth server url: %w", err)
+	}
 	uri.Path = resource
 	urlStr := uri.String()
 
-	client := &http.Client{}
-	req, _ := http.NewRequest("POST", urlStr, strings.NewReader(data.Encode())) // URL-encoded payload
+	req, err := http.NewRequestWithContext(ctx, "POST", urlStr, strings.NewReader(data.Encode())) // URL-encoded payload
+	if err != nil {
+		return Token{}, err
+	}
 
 	req.Header.Add("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Add("Content-Length", strconv.Itoa(len(data.Encode())))
 
-	resp, _ := client.Do(req)
+	resp, err := instSetting.authHttpClient.Do(req)
+	if err != nil {
+		return Token{}, err
+	}
+	defer resp.Body.Close()
 
-	buf := new(strings.Builder)
-	info, err := io.Copy(buf, resp.Body)
+	in, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.DefaultLogger.Warn("err", err)
-		log.DefaultLogger.Info("info:", info)
+		return Token{}, err
 	}
 
-	in := []byte(buf.String())
+	//The auth server describes the reason of the failure in the error fields of the OAuth2 response
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var tokenErr TokenError
+		if json.Unmarshal(in, &tokenErr) == nil && tokenErr.Error != "" {
+			if tokenErr.Error_description != "" {
+				return Token{}, fmt.Errorf("auth server answered %s: %s (%s)", resp.Status, tokenErr.Error, tokenErr.Error_description)
+			}
+			return Token{}, fmt.Errorf("auth server answered %s: %s", resp.Status, tokenErr.Error)
+		}
+		return Token{}, fmt.Errorf("auth server answered %s", resp.Status)
+	}
 
 	var token Token
-	errr := json.Unmarshal(in, &token)
-	if errr != nil {
-		log.DefaultLogger.Info("unmarshal json error :", errr)
+	err = json.Unmarshal(in, &token)
+	if err != nil {
+		return Token{}, fmt.Errorf("invalid token response from the auth server: %w", err)
+	}
+	if token.Access_token == "" {
+		return Token{}, errors.New("no access token in the auth server response")
+	}
+	return token, nil
+}
+
+// Error raised when the credentials of the datasource can't be obtained
+type authError struct {
+	err error
+}
+
+func (e *authError) Error() string {
+	return "unable to authenticate: " + e.err.Error()
+}
+
+func (e *authError) Unwrap() error {
+	return e.err
+}
+
+// Send the request to the broker with the credentials of the datasource.
+// If the broker rejects the credentials and they can be renewed, the request is sent once more with new ones.
+func doAuthenticatedRequest(r *http.Request, instSetting *instanceSettings) (*http.Response, error) {
+	if err := instSetting.auth.authenticate(r.Context(), r); err != nil {
+		return nil, &authError{err: err}
 	}
-	return string(token.Access_token)
 
+	resp, err := instSetting.httpClient.Do(r)
+	if err != nil || resp.StatusCode != http.StatusUnauthorized || !instSetting.auth.invalidate(r) {
+		return resp, err
+	}
+	resp.Body.Close()
+
+	log.DefaultLogger.Debug("credentials rejected by the broker, retrying with new ones")
+	retry := r.Clone(r.Context())
+	//The body of the first request has been read, it is sent again
+	if r.GetBody != nil {
+		retry.Body, err = r.GetBody()
+		if err != nil {
+			return nil, err
+		}
+	}
+	if err := instSetting.auth.authenticate(retry.Context(), retry); err != nil {
+		return nil, &authError{err: err}
+	}
+	return instSetting.httpClient.Do(retry)
 }
 
-func getEntityById(id string, context string, token string, instSetting *instanceSettings) []byte {
+// Get the entities of the query model, a single entity is fetched by its id
+func getEntities(ctx context.Context, qm queryModel, instSetting *instanceSettings) ([]byte, pagination, error) {
+	if isSingleEntityQuery(qm) {
+		entity, err := getEntityById(ctx, qm, instSetting)
+		return entity, pagination{}, err
+	}
+	return getEntitesByType(ctx, qm, instSetting)
+}
 
-	bToken := "Bearer " + token
+// Get an entity by its id
+func getEntityById(ctx context.Context, qm queryModel, instSetting *instanceSettings) ([]byte, error) {
 	contextBrokerUrl := instSetting.contextBrokerUrl
-	resource := "/ngsi-ld/v1/entities/" + id + "?options=sysAttrs"
+	resource := "/ngsi-ld/v1/entities/" + url.PathEscape(strings.TrimSpace(qm.EntityId))
 
-	u, _ := url.ParseRequestURI(contextBrokerUrl + resource)
+	u, err := url.ParseRequestURI(contextBrokerUrl + resource)
+	if err != nil {
+		return nil, fmt.Errorf("invalid context broker url: %w", err)
+	}
+	q := u.Query()
+	q.Set("options", "sysAttrs")
+	setProjectionParams(q, qm, instSetting)
+	setJoinParams(q, qm, instSetting)
+	u.RawQuery = q.Encode()
 	urlStr := u.String()
 
-	client := &http.Client{}
-	r, _ := http.NewRequest("GET", urlStr, nil)
+	r, err := newBrokerRequest(ctx, urlStr, qm.Context, qm.Tenant)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := doAuthenticatedRequest(r, instSetting)
+	if err != nil {
+		return nil, err
+	}
+	body, err := readBrokerResponse(resp)
+	if err != nil {
+		return nil, err
+	}
+
+	//We set the format as a list to have the same format than when you search for entities
+	return []byte("[" + string(body) + "]"), nil
+}
+
+// Build a GET request to the broker, with the JSON-LD context and the tenant of the query
+func newBrokerRequest(ctx context.Context, urlStr string, ldContext string, tenant string) (*http.Request, error) {
+	return newBrokerRequestWithBody(ctx, "GET", urlStr, nil, ldContext, tenant)
+}
 
-	r.Header.Add("Authorization", bToken)
+// Build a request to the broker sending a JSON body, with the JSON-LD context and the tenant of the query.
+// The body is nil for the requests without body.
+func newBrokerRequestWithBody(ctx context.Context, method string, urlStr string, body []byte, ldContext string, tenant string) (*http.Request, error) {
+	var bodyReader io.Reader
+	if body != nil {
+		bodyReader = bytes.NewReader(body)
+	}
+	r, err := http.NewRequestWithContext(ctx, method, urlStr, bodyReader)
+	if err != nil {
+		return nil, err
+	}
+	if body != nil {
+		r.Header.Set("Content-Type", "application/json")
+	}
 
 	//if there is a dashboard variable named "context"
-	if context != "" {
-		context = `<` + context + `>;` + `rel="http://www.w3.org/ns/json-ld#context"; type="application/ld+json"`
-		r.Header.Set("Link", context)
+	if ldContext != "" {
+		r.Header.Set("Link", contextLink(ldContext))
 	}
+	//the default tenant of the broker is used when no tenant is given
+	if tenant != "" {
+		r.Header.Set("NGSILD-Tenant", tenant)
+	}
+	return r, nil
+}
+
+// Return the Link header value giving the JSON-LD context to the broker
+func contextLink(ldContext string) string {
+	return `<` + ldContext + `>;` + `rel="http://www.w3.org/ns/json-ld#context"; type="application/ld+json"`
+}
 
-	resp, _ := client.Do(r)
+// Read the body of a broker response. If the broker answered an error,
+// the ProblemDetails it sent are returned as a brokerError.
+func readBrokerResponse(resp *http.Response) ([]byte, error) {
+	defer resp.Body.Close()
 
-	buf := new(strings.Builder)
-	n, err := io.Copy(buf, resp.Body)
+	body, err := ioutil.ReadAll(resp.Body)
 	if err != nil {
-		log.DefaultLogger.Warn("err", err)
-		log.DefaultLogger.Info("n:", n)
+		return nil, fmt.Errorf("unable to read the context broker response: %w", err)
 	}
 
-	//We set the format as a list to have the same format than when you search for entities
-	return []byte("[" + buf.String() + "]")
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		brokerErr := &brokerError{status: resp.Status, statusCode: resp.StatusCode}
+		if err := json.Unmarshal(body, &brokerErr.problem); err != nil {
+			log.DefaultLogger.Debug("context broker error is not a ProblemDetails", "body", string(body))
+		}
+		return nil, brokerErr
+	}
+	return body, nil
 }
 
-func getEntitesByType(entityType string, valueFilterQuery string, context string, token string, instSetting *instanceSettings) []byte {
+// Error answered by the broker, with the ProblemDetails describing it
+type brokerError struct {
+	status     string
+	statusCode int
+	problem    ProblemDetails
+}
+
+func (e *brokerError) Error() string {
+	if e.problem.Title != "" {
+		return "context broker answered " + e.status + ": " + problemMessage(e.problem)
+	}
+	if e.problem.Type != "" {
+		return "context broker answered " + e.status + ": " + e.problem.Type
+	}
+	return "context broker answered " + e.status
+}
+
+// Return a readable message from the ProblemDetails sent by the broker
+func problemMessage(problem ProblemDetails) string {
+	if problem.Detail != "" {
+		return problem.Title + " (" + problem.Detail + ")"
+	}
+	return problem.Title
+}
+
+// Match each link of a Link header : <target>; param1; param2
+var linkHeaderRegexp = regexp.MustCompile(`<([^>]*)>([^<]*)`)
 
-	bToken := "Bearer " + token
+// Get the entities selected by their ids, id pattern or types, following the pages of results until all entities are fetched
+// or the maximum number of entities configured in the datasource is reached.
+func getEntitesByType(ctx context.Context, qm queryModel, instSetting *instanceSettings) ([]byte, pagination, error) {
 	contextBrokerUrl := instSetting.contextBrokerUrl
-	resource := "/ngsi-ld/v1/entities?type=" + entityType + "&options=sysAttrs"
+	resource := "/ngsi-ld/v1/entities"
+
+	u, err := url.ParseRequestURI(contextBrokerUrl + resource)
+	if err != nil {
+		return nil, pagination{}, fmt.Errorf("invalid context broker url: %w", err)
+	}
+	q := u.Query()
+	setEntitySelectionParams(q, qm)
+	q.Set("options", "sysAttrs")
+	//if the user specified any query parameters, we add them to the query
+	if qm.ValueFilterQuery != "" {
+		q.Set("q", qm.ValueFilterQuery)
+	}
+	setGeoQueryParams(q, qm)
+	setScopeQueryParams(q, qm)
+	setProjectionParams(q, qm, instSetting)
+	setJoinParams(q, qm, instSetting)
+	u.RawQuery = q.Encode()
+
+	return getPages(ctx, u, qm, instSetting)
+}
+
+// Get the entities of a query url, following the pages of results until all entities are fetched
+// or the maximum number of entities configured in the datasource is reached.
+func getPages(ctx context.Context, u *url.URL, qm queryModel, instSetting *instanceSettings) ([]byte, pagination, error) {
+	var entities []json.RawMessage
+	var pages pagination
 
-	u, _ := url.ParseRequestURI(contextBrokerUrl + resource)
+	q := u.Query()
+	q.Set("count", "true")
+	q.Set("limit", strconv.Itoa(instSetting.pageSize))
+	u.RawQuery = q.Encode()
 	urlStr := u.String()
 
-	client := &http.Client{}
-	r, _ := http.NewRequest("GET", urlStr, nil)
+	for urlStr != "" {
+		r, err := newBrokerRequest(ctx, urlStr, qm.Context, qm.Tenant)
+		if err != nil {
+			return nil, pages, err
+		}
 
-	r.Header.Add("Authorization", bToken)
+		resp, err := doAuthenticatedRequest(r, instSetting)
+		if err != nil {
+			return nil, pages, err
+		}
+		body, err := readBrokerResponse(resp)
+		if err != nil {
+			return nil, pages, err
+		}
+		checkPartialContent(resp, &pages)
 
-	//if there is a dashboard variable named "context"
-	if context != "" {
-		context = `<` + context + `>;` + `rel="http://www.w3.org/ns/json-ld#context"; type="application/ld+json"`
-		r.Header.Set("Link", context)
+		//The total number of entities is only sent when count=true
+		if resultsCount, err := strconv.Atoi(resp.Header.Get("NGSILD-Results-Count")); err == nil {
+			pages.resultsCount = resultsCount
+		}
+
+		var page []json.RawMessage
+		if err := json.Unmarshal(body, &page); err != nil {
+			return nil, pages, fmt.Errorf("invalid entities response from the context broker: %w", err)
+		}
+		entities = append(entities, page...)
+
+		//Use the next page given by the broker, or compute it when the broker does not send the Link header.
+		//A broker can send less entities than the limit in a page, the results count tells when there are more.
+		next := nextPageUrl(resp.Header, u)
+		morePages := len(entities) < pages.resultsCount || (pages.resultsCount == 0 && len(page) == instSetting.pageSize)
+		if next == "" && len(page) > 0 && morePages {
+			q.Set("offset", strconv.Itoa(len(entities)))
+			u.RawQuery = q.Encode()
+			next = u.String()
+		}
+		//A broker sending an empty page or the same page again would never end the paging
+		if len(page) == 0 || next == urlStr {
+			next = ""
+		}
+
+		if len(entities) > instSetting.maxEntities {
+			entities = entities[:instSetting.maxEntities]
+			pages.truncated = true
+			break
+		}
+		if len(entities) == instSetting.maxEntities {
+			pages.truncated = next != ""
+			break
+		}
+		urlStr = next
 	}
+	//The paging may also end before all the entities counted by the broker are fetched
+	if len(entities) < pages.resultsCount {
+		pages.truncated = true
+	}
+	pages.fetched = len(entities)
 
-	//if the user specified any query parameters, we add them to the query
-	if valueFilterQuery != "" {
-		q := r.URL.Query()
-		q.Add("q", valueFilterQuery)
-		r.URL.RawQuery = q.Encode()
+	if entities == nil {
+		return []byte("[]"), pages, nil
 	}
+	result, err := json.Marshal(entities)
+	return result, pages, err
+}
 
-	resp, _ := client.Do(r)
+// Note when the broker only sent a part of the temporal values of the entities, with the range it sent
+func checkPartialContent(resp *http.Response, pages *pagination) {
+	if resp.StatusCode == http.StatusPartialContent {
+		pages.partialValues = true
+		pages.partialRange = resp.Header.Get("Content-Range")
+	}
+}
+
+// Return the url of the next page sent in the Link header with rel="next", or an empty string if there is none
+func nextPageUrl(header http.Header, base *url.URL) string {
+	for _, links := range header.Values("Link") {
+		for _, link := range linkHeaderRegexp.FindAllStringSubmatch(links, -1) {
+			if !strings.Contains(strings.ReplaceAll(link[2], " ", ""), `rel="next"`) {
+				continue
+			}
+			next, err := base.Parse(link[1])
+			if err != nil {
+				return ""
+			}
+			return next.String()
+		}
+	}
+	return ""
+}
+
+// Get the temporal evolution of entities between the two dates of the time range.
+// If a single entity id is given, only this entity is requested, otherwise the entities are selected
+// by their ids, id pattern or types and their pages are followed like for the entities queries.
+func getTemporalEntities(ctx context.Context, qm queryModel, timeRange backend.TimeRange, instSetting *instanceSettings) ([]byte, pagination, error) {
+	contextBrokerUrl := instSetting.contextBrokerUrl
+	resource := "/ngsi-ld/v1/temporal/entities"
+	singleEntity := isSingleEntityQuery(qm)
+	if singleEntity {
+		resource = resource + "/" + url.PathEscape(strings.TrimSpace(qm.EntityId))
+	}
 
-	buf := new(strings.Builder)
-	n, err := io.Copy(buf, resp.Body)
+	u, err := url.ParseRequestURI(contextBrokerUrl + resource)
 	if err != nil {
-		log.DefaultLogger.Warn("err", err)
-		log.DefaultLogger.Info("n:", n)
+		return nil, pagination{}, fmt.Errorf("invalid context broker url: %w", err)
 	}
 
-	return []byte(buf.String())
+	q := u.Query()
+	if !singleEntity {
+		setEntitySelectionParams(q, qm)
+		if qm.ValueFilterQuery != "" {
+			q.Set("q", qm.ValueFilterQuery)
+		}
+		setGeoQueryParams(q, qm)
+		setScopeQueryParams(q, qm)
+	}
+	//if the user chose attributes, we only ask for their temporal evolution
+	if attrs := splitList(qm.Attrs); len(attrs) > 0 {
+		q.Set("attrs", strings.Join(attrs, ","))
+	} else if qm.MapMetric != "" {
+		q.Set("attrs", qm.MapMetric)
+	}
+	if qm.TimeProperty != "" {
+		q.Set("timeproperty", qm.TimeProperty)
+	}
+	//if the user chose aggregation methods, the broker computes the aggregated values for us
+	if aggrMethods := splitList(qm.AggrMethods); len(aggrMethods) > 0 {
+		q.Set("options", "aggregatedValues")
+		q.Set("aggrMethods", strings.Join(aggrMethods, ","))
+		if qm.AggrPeriodDuration != "" {
+			q.Set("aggrPeriodDuration", qm.AggrPeriodDuration)
+		}
+	}
+	q.Set("timerel", "between")
+	q.Set("timeAt", timeRange.From.UTC().Format(time.RFC3339))
+	q.Set("endTimeAt", timeRange.To.UTC().Format(time.RFC3339))
+	u.RawQuery = q.Encode()
 
+	//The entities are paged like the entities queries
+	if !singleEntity {
+		return getPages(ctx, u, qm, instSetting)
+	}
+
+	var pages pagination
+	r, err := newBrokerRequest(ctx, u.String(), qm.Context, qm.Tenant)
+	if err != nil {
+		return nil, pages, err
+	}
+
+	resp, err := doAuthenticatedRequest(r, instSetting)
+	if err != nil {
+		return nil, pages, err
+	}
+	body, err := readBrokerResponse(resp)
+	if err != nil {
+		return nil, pages, err
+	}
+	checkPartialContent(resp, &pages)
+
+	//We set the format as a list to have the same format than when you search for entities
+	return []byte("[" + string(body) + "]"), pages, nil
 }
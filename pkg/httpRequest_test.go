@@ -0,0 +1,286 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestGetEntitiesByTypePaging(t *testing.T) {
+	tests := []struct {
+		name         string
+		pages        []string
+		samePage     bool
+		wantEntities string
+		wantRequests int
+	}{
+		{
+			name:         "next pages until an empty one",
+			pages:        []string{`[{"id":"urn:a"}]`, `[{"id":"urn:b"}]`, `[{"id":"urn:c"}]`},
+			wantEntities: `[{"id":"urn:a"},{"id":"urn:b"},{"id":"urn:c"}]`,
+			wantRequests: 4,
+		},
+		{
+			name:         "empty page with a next link",
+			pages:        []string{`[{"id":"urn:a"}]`, `[]`, `[{"id":"urn:c"}]`},
+			wantEntities: `[{"id":"urn:a"}]`,
+			wantRequests: 2,
+		},
+		{
+			name:         "next link to the same page",
+			pages:        []string{`[{"id":"urn:a"}]`, `[{"id":"urn:b"}]`},
+			samePage:     true,
+			wantEntities: `[{"id":"urn:a"}]`,
+			wantRequests: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				page := requests
+				requests++
+				//Every page links to a next one, the broker never tells the paging is over
+				next := fmt.Sprintf("/ngsi-ld/v1/entities?page=%d", page+1)
+				if tt.samePage {
+					next = r.URL.RequestURI()
+				}
+				w.Header().Set("Link", "<"+next+`>; rel="next"`)
+				if page < len(tt.pages) {
+					w.Write([]byte(tt.pages[page]))
+				} else {
+					w.Write([]byte(`[]`))
+				}
+			}))
+			defer broker.Close()
+
+			instSetting := newTestInstance(t, map[string]interface{}{"contextBrokerUrl": broker.URL, "pageSize": 1})
+			entities, pages, err := getEntitesByType(context.Background(), queryModel{EntityType: "Sensor"}, instSetting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(entities) != tt.wantEntities {
+				t.Errorf("entities = %s, want %s", entities, tt.wantEntities)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("%d requests sent to the broker, want %d", requests, tt.wantRequests)
+			}
+			if pages.truncated {
+				t.Error("entities reported as truncated")
+			}
+		})
+	}
+}
+
+func TestDoAuthenticatedRequest(t *testing.T) {
+	tests := []struct {
+		name string
+		//Validity of the access tokens given by the auth server, they are renewed right away under the expiry margin
+		expiresIn int
+		//Validity of the refresh tokens, 0 for the offline tokens that do not expire
+		refreshExpiresIn int
+		//Tell if the broker rejects the token of a request
+		rejects          func(token string) bool
+		requests         int
+		wantStatus       int
+		wantGrants       []string
+		wantBrokerTokens []string
+	}{
+		{
+			name:             "token reused while it is valid",
+			expiresIn:        300,
+			rejects:          func(token string) bool { return false },
+			requests:         2,
+			wantStatus:       http.StatusOK,
+			wantGrants:       []string{"client_credentials"},
+			wantBrokerTokens: []string{"token-1", "token-1"},
+		},
+		{
+			name:             "expired token renewed with the refresh token",
+			expiresIn:        1,
+			refreshExpiresIn: 1800,
+			rejects:          func(token string) bool { return false },
+			requests:         2,
+			wantStatus:       http.StatusOK,
+			wantGrants:       []string{"client_credentials", "refresh_token"},
+			wantBrokerTokens: []string{"token-1", "token-2"},
+		},
+		{
+			name:             "expired token renewed with an offline refresh token",
+			expiresIn:        1,
+			refreshExpiresIn: 0,
+			rejects:          func(token string) bool { return false },
+			requests:         2,
+			wantStatus:       http.StatusOK,
+			wantGrants:       []string{"client_credentials", "refresh_token"},
+			wantBrokerTokens: []string{"token-1", "token-2"},
+		},
+		{
+			name:             "rejected token renewed and request retried",
+			expiresIn:        300,
+			rejects:          func(token string) bool { return token == "token-1" },
+			requests:         1,
+			wantStatus:       http.StatusOK,
+			wantGrants:       []string{"client_credentials", "client_credentials"},
+			wantBrokerTokens: []string{"token-1", "token-2"},
+		},
+		{
+			name:             "request retried only once",
+			expiresIn:        300,
+			rejects:          func(token string) bool { return true },
+			requests:         1,
+			wantStatus:       http.StatusUnauthorized,
+			wantGrants:       []string{"client_credentials", "client_credentials"},
+			wantBrokerTokens: []string{"token-1", "token-2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var grants []string
+			authServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				r.ParseForm()
+				grants = append(grants, r.PostForm.Get("grant_type"))
+				if r.PostForm.Get("grant_type") == "refresh_token" && r.PostForm.Get("refresh_token") != fmt.Sprintf("refresh-%d", len(grants)-1) {
+					t.Errorf("refresh token = %q", r.PostForm.Get("refresh_token"))
+				}
+				fmt.Fprintf(w, `{"access_token":"token-%d","expires_in":%d,"refresh_token":"refresh-%d","refresh_expires_in":%d}`,
+					len(grants), tt.expiresIn, len(grants), tt.refreshExpiresIn)
+			}))
+			defer authServer.Close()
+
+			var brokerTokens []string
+			broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+				brokerTokens = append(brokerTokens, token)
+				//The body of a rejected request is sent again with the retry
+				if body, _ := ioutil.ReadAll(r.Body); string(body) != `{"id":"urn:a"}` {
+					t.Errorf("request body = %q", body)
+				}
+				if tt.rejects(token) {
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			}))
+			defer broker.Close()
+
+			instSetting := newTestInstance(t, map[string]interface{}{
+				"contextBrokerUrl": broker.URL,
+				"authMode":         "clientCredentials",
+				"authServerUrl":    authServer.URL,
+				"resource":         "/token",
+				"clientId":         "grafana",
+			})
+			for i := 0; i < tt.requests; i++ {
+				r, err := newBrokerRequestWithBody(context.Background(), http.MethodPost, broker.URL+"/ngsi-ld/v1/entities", []byte(`{"id":"urn:a"}`), "", "")
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp, err := doAuthenticatedRequest(r, instSetting)
+				if err != nil {
+					t.Fatal(err)
+				}
+				resp.Body.Close()
+				if resp.StatusCode != tt.wantStatus {
+					t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+				}
+			}
+			if strings.Join(grants, " ") != strings.Join(tt.wantGrants, " ") {
+				t.Errorf("grants = %v, want %v", grants, tt.wantGrants)
+			}
+			if strings.Join(brokerTokens, " ") != strings.Join(tt.wantBrokerTokens, " ") {
+				t.Errorf("tokens sent to the broker = %v, want %v", brokerTokens, tt.wantBrokerTokens)
+			}
+		})
+	}
+}
+
+func TestGetEntitiesByTypeResultsCount(t *testing.T) {
+	tests := []struct {
+		name          string
+		available     int
+		pageCap       int
+		wantEntities  int
+		wantRequests  int
+		wantTruncated bool
+	}{
+		{name: "pages of the limit", available: 5, pageCap: 5, wantEntities: 5, wantRequests: 1},
+		{name: "pages capped by the broker", available: 5, pageCap: 2, wantEntities: 5, wantRequests: 3},
+		{name: "broker stopping before the count", available: 3, pageCap: 2, wantEntities: 3, wantRequests: 3, wantTruncated: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			requests := 0
+			broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				requests++
+				//The broker counts 5 entities, but only sends the available ones by pages of at most pageCap entities
+				w.Header().Set("NGSILD-Results-Count", "5")
+				offset := 0
+				fmt.Sscan(r.URL.Query().Get("offset"), &offset)
+				var page []string
+				for i := offset; i < tt.available && len(page) < tt.pageCap; i++ {
+					page = append(page, fmt.Sprintf(`{"id":"urn:%d"}`, i))
+				}
+				w.Write([]byte("[" + strings.Join(page, ",") + "]"))
+			}))
+			defer broker.Close()
+
+			instSetting := newTestInstance(t, map[string]interface{}{"contextBrokerUrl": broker.URL, "pageSize": 5})
+			entities, pages, err := getEntitesByType(context.Background(), queryModel{EntityType: "Sensor"}, instSetting)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count := strings.Count(string(entities), `"id"`); count != tt.wantEntities || pages.fetched != tt.wantEntities {
+				t.Errorf("%d entities fetched (%d reported), want %d", count, pages.fetched, tt.wantEntities)
+			}
+			if requests != tt.wantRequests {
+				t.Errorf("%d requests sent to the broker, want %d", requests, tt.wantRequests)
+			}
+			if pages.truncated != tt.wantTruncated {
+				t.Errorf("truncated = %v, want %v", pages.truncated, tt.wantTruncated)
+			}
+		})
+	}
+}
+
+func TestGetTemporalEntitiesPaging(t *testing.T) {
+	var queries []string
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		queries = append(queries, r.URL.RawQuery)
+		w.Header().Set("NGSILD-Results-Count", "3")
+		if r.URL.Query().Get("offset") == "" {
+			w.Write([]byte(`[{"id":"urn:a"},{"id":"urn:b"}]`))
+			return
+		}
+		//The broker only sends the values it can of the last entity
+		w.Header().Set("Content-Range", "date-time 2022-01-01T00:00:00Z-2022-01-01T12:00:00Z/*")
+		w.WriteHeader(http.StatusPartialContent)
+		w.Write([]byte(`[{"id":"urn:c"}]`))
+	}))
+	defer broker.Close()
+
+	instSetting := newTestInstance(t, map[string]interface{}{"contextBrokerUrl": broker.URL, "pageSize": 2})
+	qm := queryModel{QueryMode: "temporal", EntityType: "Sensor", AggrMethods: "sum, avg"}
+	entities, pages, err := getTemporalEntities(context.Background(), qm, backend.TimeRange{From: time.Now().Add(-time.Hour), To: time.Now()}, instSetting)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(entities) != `[{"id":"urn:a"},{"id":"urn:b"},{"id":"urn:c"}]` {
+		t.Errorf("entities = %s", entities)
+	}
+	if len(queries) != 2 || !strings.Contains(queries[0], "limit=2") || !strings.Contains(queries[1], "offset=2") ||
+		!strings.Contains(queries[0], "aggrMethods=sum%2Cavg&") {
+		t.Errorf("queries sent to the broker = %v", queries)
+	}
+	if pages.resultsCount != 3 || pages.truncated {
+		t.Errorf("pagination = %+v", pages)
+	}
+	if !pages.partialValues || !strings.HasPrefix(pages.partialRange, "date-time") {
+		t.Errorf("partial values not reported: %+v", pages)
+	}
+}
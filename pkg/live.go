@@ -0,0 +1,298 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+	"github.com/grafana/grafana-plugin-sdk-go/live"
+)
+
+// Error ending the streams of a disposed datasource instance, Grafana runs again the streams ending with an error
+var errInstanceDisposed = errors.New("the datasource settings changed, the live stream is restarted with the new ones")
+
+// Time a live query is kept when no stream runs it, after its last run or the end of its last stream.
+// The panels subscribe to the channel right after running the query.
+const liveQueryTTL = 10 * time.Minute
+
+// Live queries, notification receivers and pollers of the datasources.
+// A live query answers frames with a Grafana Live channel, the panels subscribe to it and Grafana runs
+// the stream of the channel while it has subscribers : the plugin subscribes to the changes of the entities
+// on the broker and sends the notified entities to the channel. When the broker can't reach the plugin,
+// the datasource has no notification URL and the query is run again at regular intervals instead.
+type liveStreams struct {
+	mu sync.Mutex
+	//Query of each channel, by datasource and channel path
+	queries map[string]*liveQuery
+	//Notification receiver of each listen address, shared by the datasources and closed by the last stream
+	receivers map[string]*notificationReceiver
+	//Poller of each polled channel, by datasource and channel path
+	pollers map[string]*poller
+}
+
+// Query of a live channel
+type liveQuery struct {
+	qm queryModel
+	//Last run of the query or end of its last stream, the query is forgotten after the TTL when no stream runs it
+	lastUsed time.Time
+	//Number of streams running for the channel
+	streams int
+}
+
+func newLiveStreams() *liveStreams {
+	return &liveStreams{
+		queries:   map[string]*liveQuery{},
+		receivers: map[string]*notificationReceiver{},
+		pollers:   map[string]*poller{},
+	}
+}
+
+// Return the address the plugin listens to for the notifications : the listen address of the settings,
+// or the port of the notification URL. There is no address without notification URL.
+func notificationListenAddress(settings settingsModel) (string, error) {
+	if settings.NotificationUrl == "" {
+		return "", nil
+	}
+	u, err := url.ParseRequestURI(settings.NotificationUrl)
+	if err != nil {
+		return "", fmt.Errorf("invalid notification url: %w", err)
+	}
+	if settings.ListenAddress != "" {
+		return settings.ListenAddress, nil
+	}
+	if u.Port() == "" {
+		return "", errors.New("the notification url needs a port, or a listen address must be given")
+	}
+	return ":" + u.Port(), nil
+}
+
+// Check that the query can be live : only the entities queries are live, and the broker
+// notifies the entities of a type. Any entities query can be polled.
+func validateLiveQuery(qm queryModel, instSetting *instanceSettings) error {
+	if qm.QueryMode == "temporal" || qm.QueryMode == "variable" {
+		return errors.New("live updates are only available for the entities queries")
+	}
+	if instSetting.notificationUrl != "" && strings.TrimSpace(qm.EntityType) == "" {
+		return errors.New("live updates need an entity type, the broker notifies the entities of a type")
+	}
+	return nil
+}
+
+// Register the query model of a live query and return its channel, the expired queries are forgotten.
+// The channel path is computed from the query model, the panels running the same query share it.
+func (l *liveStreams) channel(instSetting *instanceSettings, qm queryModel) (string, error) {
+	jsonQuery, err := json.Marshal(qm)
+	if err != nil {
+		return "", err
+	}
+	hash := sha256.Sum256(jsonQuery)
+	channel := live.Channel{
+		Scope:     live.ScopeDatasource,
+		Namespace: instSetting.uid,
+		Path:      "entities/" + hex.EncodeToString(hash[:16]),
+	}
+
+	now := time.Now()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for key, query := range l.queries {
+		if query.streams == 0 && now.Sub(query.lastUsed) > liveQueryTTL {
+			delete(l.queries, key)
+		}
+	}
+	key := instSetting.uid + "/" + channel.Path
+	if query, found := l.queries[key]; found {
+		query.lastUsed = now
+	} else {
+		l.queries[key] = &liveQuery{qm: qm, lastUsed: now}
+	}
+	return channel.String(), nil
+}
+
+// Return the query model of a channel path of a datasource
+func (l *liveStreams) query(instSetting *instanceSettings, path string) (queryModel, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	query, found := l.queries[instSetting.uid+"/"+path]
+	if !found {
+		return queryModel{}, false
+	}
+	return query.qm, true
+}
+
+// Start a stream of a channel path of a datasource, its query is kept while the stream runs.
+// Return the query model and the function to call when the stream ends.
+func (l *liveStreams) startStream(instSetting *instanceSettings, path string) (queryModel, func(), bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	query, found := l.queries[instSetting.uid+"/"+path]
+	if !found {
+		return queryModel{}, nil, false
+	}
+	query.streams++
+	return query.qm, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		query.streams--
+		query.lastUsed = time.Now()
+	}, true
+}
+
+// Register a stream on the notification receiver listening to the address, the receiver is started by the first stream.
+// Return the receiver, the token of the stream and the channel of the entities notified to the stream.
+func (l *liveStreams) listen(address string) (*notificationReceiver, string, <-chan []byte, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	receiver, found := l.receivers[address]
+	if !found {
+		var err error
+		receiver, err = startNotificationReceiver(address)
+		if err != nil {
+			return nil, "", nil, fmt.Errorf("unable to receive the notifications on %s: %w", address, err)
+		}
+		l.receivers[address] = receiver
+	}
+	token, notifications, err := receiver.register()
+	if err != nil {
+		return nil, "", nil, err
+	}
+	return receiver, token, notifications, nil
+}
+
+// Unregister a stream from its notification receiver, the receiver stops listening after its last stream
+func (l *liveStreams) stopListening(address string, receiver *notificationReceiver, token string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if receiver.unregister(token) > 0 {
+		return
+	}
+	receiver.close()
+	if l.receivers[address] == receiver {
+		delete(l.receivers, address)
+	}
+}
+
+// Give the live channel to the frames, the panels subscribe to it
+func addLiveChannel(frames data.Frames, channel string) {
+	for _, frame := range frames {
+		if frame.Meta == nil {
+			frame.Meta = &data.FrameMeta{}
+		}
+		frame.Meta.Channel = channel
+	}
+}
+
+// SubscribeStream accepts the subscriptions to the channels of the live queries run by the datasource.
+// The queries are only known by the plugin after they have been run, a panel runs its query before subscribing.
+func (td *SampleDatasource) SubscribeStream(ctx context.Context, req *backend.SubscribeStreamRequest) (*backend.SubscribeStreamResponse, error) {
+	instance, err := td.im.Get(req.PluginContext)
+	if err != nil {
+		return nil, err
+	}
+	instSetting, _ := instance.(*instanceSettings)
+
+	if _, found := td.live.query(instSetting, req.Path); !found {
+		return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusNotFound}, nil
+	}
+	return &backend.SubscribeStreamResponse{Status: backend.SubscribeStreamStatusOK}, nil
+}
+
+// PublishStream refuses the publications, the entities only come from the broker
+func (td *SampleDatasource) PublishStream(ctx context.Context, req *backend.PublishStreamRequest) (*backend.PublishStreamResponse, error) {
+	return &backend.PublishStreamResponse{Status: backend.PublishStreamStatusPermissionDenied}, nil
+}
+
+// RunStream sends the changes of the entities of a live query to the channel, in the format of the query.
+// Grafana cancels the context when the last subscriber leaves the channel.
+func (td *SampleDatasource) RunStream(ctx context.Context, req *backend.RunStreamRequest, sender *backend.StreamSender) error {
+	instance, err := td.im.Get(req.PluginContext)
+	if err != nil {
+		return err
+	}
+	instSetting, _ := instance.(*instanceSettings)
+
+	qm, stop, found := td.live.startStream(instSetting, req.Path)
+	if !found {
+		return fmt.Errorf("unknown live channel %s", req.Path)
+	}
+	defer stop()
+
+	//The stream stops when the datasource instance is disposed, its settings are no longer the current ones
+	ctx, cancel := instanceContext(ctx, instSetting)
+	defer cancel()
+	if instSetting.notificationUrl == "" {
+		err = td.live.poll(ctx, instSetting, req.Path, qm, sender)
+	} else {
+		err = td.live.subscribe(ctx, instSetting, req.Path, qm, sender)
+	}
+	if err == nil && instSetting.disposed.Err() != nil {
+		return errInstanceDisposed
+	}
+	return err
+}
+
+// Return a context done when the context or the datasource instance is done
+func instanceContext(ctx context.Context, instSetting *instanceSettings) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		select {
+		case <-instSetting.disposed.Done():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	return ctx, cancel
+}
+
+// Subscribe to the changes of the entities of a live query on the broker and send the notified entities to the stream,
+// until the context of the stream is done. The subscription is then deleted.
+func (l *liveStreams) subscribe(ctx context.Context, instSetting *instanceSettings, path string, qm queryModel, sender *backend.StreamSender) error {
+	receiver, token, notifications, err := l.listen(instSetting.listenAddress)
+	if err != nil {
+		return err
+	}
+	defer l.stopListening(instSetting.listenAddress, receiver, token)
+
+	endpoint := strings.TrimSuffix(instSetting.notificationUrl, "/") + "/" + token
+	subscriptionId, err := createSubscription(ctx, qm, endpoint, instSetting)
+	if err != nil {
+		return err
+	}
+	log.DefaultLogger.Debug("live stream started", "path", path, "subscription", subscriptionId)
+	defer func() {
+		//The context of the stream is done, the subscription is deleted with a context of its own
+		deleteCtx, cancel := context.WithTimeout(context.Background(), instSetting.timeout)
+		defer cancel()
+		if err := deleteSubscription(deleteCtx, qm, subscriptionId, instSetting); err != nil {
+			log.DefaultLogger.Warn("unable to delete the subscription of a live stream", "subscription", subscriptionId, "err", err)
+		}
+	}()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case entities := <-notifications:
+			response := transformEntities(ctx, qm, entities, instSetting, backend.DataResponse{})
+			if response.Error != nil {
+				log.DefaultLogger.Warn("unable to transform the notified entities", "path", path, "err", response.Error)
+				continue
+			}
+			for _, frame := range response.Frames {
+				if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/instancemgmt"
+)
+
+// Instance manager always giving the same datasource instance
+type testInstanceManager struct {
+	instSetting *instanceSettings
+}
+
+func (m testInstanceManager) Get(backend.PluginContext) (instancemgmt.Instance, error) {
+	return m.instSetting, nil
+}
+
+func (m testInstanceManager) Do(backend.PluginContext, instancemgmt.InstanceCallbackFunc) error {
+	return nil
+}
+
+// Stream packet sender keeping the packets sent to Grafana
+type testPacketSender struct {
+	packets chan *backend.StreamPacket
+}
+
+func (s testPacketSender) Send(packet *backend.StreamPacket) error {
+	s.packets <- packet
+	return nil
+}
+
+func newTestInstance(t *testing.T, jsonData map[string]interface{}) *instanceSettings {
+	t.Helper()
+	jsonSettings, _ := json.Marshal(jsonData)
+	instance, err := newDataSourceInstance(backend.DataSourceInstanceSettings{UID: "ngsild", JSONData: jsonSettings})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return instance.(*instanceSettings)
+}
+
+// Return a free local port for the notification receiver
+func freePort(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	return strings.TrimPrefix(listener.Addr().String(), "127.0.0.1:")
+}
+
+func TestCreateSubscription(t *testing.T) {
+	tests := []struct {
+		name     string
+		location string
+		wantId   string
+		wantErr  bool
+	}{
+		{name: "relative location", location: "/ngsi-ld/v1/subscriptions/urn:ngsi-ld:Subscription:1", wantId: "urn:ngsi-ld:Subscription:1"},
+		{name: "absolute location", location: "http://broker/ngsi-ld/v1/subscriptions/urn%3Angsi-ld%3ASubscription%3A2", wantId: "urn:ngsi-ld:Subscription:2"},
+		{name: "no location", location: "", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var received subscription
+			broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.Method != http.MethodPost || r.URL.Path != "/ngsi-ld/v1/subscriptions" {
+					t.Errorf("unexpected request %s %s", r.Method, r.URL.Path)
+				}
+				json.NewDecoder(r.Body).Decode(&received)
+				if tt.location != "" {
+					w.Header().Set("Location", tt.location)
+				}
+				w.WriteHeader(http.StatusCreated)
+			}))
+			defer broker.Close()
+
+			instSetting := newTestInstance(t, map[string]interface{}{"contextBrokerUrl": broker.URL})
+			qm := queryModel{EntityType: "Sensor,Device", Attrs: "temperature"}
+			id, err := createSubscription(context.Background(), qm, "http://grafana:8090/notify/token", instSetting)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("createSubscription() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if id != tt.wantId {
+				t.Errorf("createSubscription() id = %q, want %q", id, tt.wantId)
+			}
+			if len(received.Entities) != 2 || received.Entities[0].Type != "Sensor" || received.Entities[1].Type != "Device" {
+				t.Errorf("subscription entities = %+v, want one selector per type", received.Entities)
+			}
+			if received.Notification.Endpoint.Uri != "http://grafana:8090/notify/token" {
+				t.Errorf("subscription endpoint = %q", received.Notification.Endpoint.Uri)
+			}
+		})
+	}
+}
+
+func TestNotificationReceiver(t *testing.T) {
+	receiver := &notificationReceiver{streams: map[string]chan []byte{}}
+	server := httptest.NewServer(receiver)
+	defer server.Close()
+
+	token, entities, err := receiver.register()
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		name       string
+		path       string
+		body       string
+		wantStatus int
+		wantData   string
+	}{
+		{
+			name:       "notification of a registered stream",
+			path:       "/prefix/" + token,
+			body:       `{"id":"urn:n:1","type":"Notification","subscriptionId":"urn:s:1","data":[{"id":"urn:a","type":"Sensor"}]}`,
+			wantStatus: http.StatusNoContent,
+			wantData:   `[{"id":"urn:a","type":"Sensor"}]`,
+		},
+		{name: "unknown stream", path: "/prefix/unknown", body: `{"data":[]}`, wantStatus: http.StatusNotFound},
+		{name: "not a notification", path: "/prefix/" + token, body: `[`, wantStatus: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, err := http.Post(server.URL+tt.path, "application/json", strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			resp.Body.Close()
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantData == "" {
+				return
+			}
+			select {
+			case data := <-entities:
+				if string(data) != tt.wantData {
+					t.Errorf("notified entities = %s, want %s", data, tt.wantData)
+				}
+			case <-time.After(time.Second):
+				t.Error("the notified entities did not reach the stream")
+			}
+		})
+	}
+
+	if remaining := receiver.unregister(token); remaining != 0 {
+		t.Errorf("unregister() = %d streams, want 0", remaining)
+	}
+}
+
+func TestRunStreamDeletesSubscription(t *testing.T) {
+	tests := []struct {
+		name string
+		//Stop the stream, by cancelling its context or disposing the datasource instance
+		stop    func(cancel context.CancelFunc, instSetting *instanceSettings)
+		wantErr error
+	}{
+		{
+			name: "the last subscriber leaves the channel",
+			stop: func(cancel context.CancelFunc, _ *instanceSettings) { cancel() },
+		},
+		{
+			name:    "the datasource settings change",
+			stop:    func(_ context.CancelFunc, instSetting *instanceSettings) { instSetting.Dispose() },
+			wantErr: errInstanceDisposed,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			endpoints := make(chan string, 1)
+			deleted := make(chan string, 1)
+			broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				switch r.Method {
+				case http.MethodGet:
+					w.Write([]byte(`[{"id":"urn:a","type":"Sensor","temperature":{"type":"Property","value":20}}]`))
+				case http.MethodPost:
+					var received subscription
+					json.NewDecoder(r.Body).Decode(&received)
+					endpoints <- received.Notification.Endpoint.Uri
+					w.Header().Set("Location", "/ngsi-ld/v1/subscriptions/urn:ngsi-ld:Subscription:1")
+					w.WriteHeader(http.StatusCreated)
+				case http.MethodDelete:
+					deleted <- r.URL.Path
+					w.WriteHeader(http.StatusNoContent)
+				}
+			}))
+			defer broker.Close()
+
+			instSetting := newTestInstance(t, map[string]interface{}{
+				"contextBrokerUrl": broker.URL,
+				"notificationUrl":  "http://127.0.0.1:" + freePort(t) + "/notify",
+			})
+			td := &SampleDatasource{im: testInstanceManager{instSetting}, live: newLiveStreams()}
+
+			//The panel runs the live query, then subscribes to its channel
+			resp, err := td.QueryData(context.Background(), &backend.QueryDataRequest{
+				Queries: []backend.DataQuery{{RefID: "A", JSON: []byte(`{"entityType":"Sensor","format":"wide","live":true}`)}},
+			})
+			if err != nil || resp.Responses["A"].Error != nil {
+				t.Fatal(err, resp.Responses["A"].Error)
+			}
+			channel := resp.Responses["A"].Frames[0].Meta.Channel
+			path := strings.SplitN(channel, "/", 3)[2]
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			sender := testPacketSender{packets: make(chan *backend.StreamPacket, 1)}
+			done := make(chan error, 1)
+			go func() {
+				done <- td.RunStream(ctx, &backend.RunStreamRequest{Path: path}, backend.NewStreamSender(sender))
+			}()
+
+			//The broker notifies a change to the endpoint of the subscription
+			var endpoint string
+			select {
+			case endpoint = <-endpoints:
+			case <-time.After(3 * time.Second):
+				t.Fatal("no subscription created")
+			}
+			notification, err := http.Post(endpoint, "application/json",
+				strings.NewReader(`{"type":"Notification","data":[{"id":"urn:a","type":"Sensor","temperature":{"type":"Property","value":21}}]}`))
+			if err != nil {
+				t.Fatal(err)
+			}
+			notification.Body.Close()
+			select {
+			case packet := <-sender.packets:
+				if !strings.Contains(string(packet.Data), `"urn:a"`) {
+					t.Errorf("frame sent to the channel = %s", packet.Data)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("no frame sent to the channel")
+			}
+
+			tt.stop(cancel, instSetting)
+			select {
+			case path := <-deleted:
+				if path != "/ngsi-ld/v1/subscriptions/urn:ngsi-ld:Subscription:1" {
+					t.Errorf("deleted %s", path)
+				}
+			case <-time.After(3 * time.Second):
+				t.Fatal("the subscription was not deleted")
+			}
+			if err := <-done; err != tt.wantErr {
+				t.Errorf("RunStream() error = %v, want %v", err, tt.wantErr)
+			}
+
+			td.live.mu.Lock()
+			defer td.live.mu.Unlock()
+			if len(td.live.receivers) != 0 {
+				t.Errorf("%d notification receivers still listening", len(td.live.receivers))
+			}
+			if query := td.live.queries[instSetting.uid+"/"+path]; query == nil || query.streams != 0 {
+				t.Errorf("live query after the stream = %+v", query)
+			}
+		})
+	}
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"encoding/json"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Return a DataResponse to display the entities and their relationships in the Node Graph panel
+// (The dataResponse contains a nodes frame with one row per entity : id, title (type), subtitle (id), mainStat
+// (value of the attribute), and an edges frame with one row per target of a relationship : id, source, target, mainStat)
+func transformToNodeGraph(qm queryModel, entitiesByte []byte, response backend.DataResponse) backend.DataResponse {
+	var entities []map[string]interface{}
+	err := json.Unmarshal(entitiesByte, &entities)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	//Store each value on a slice
+	var nodeIds []string
+	var titles []string
+	var mainStats = newValueColumn("mainStat")
+	var edgeIds []string
+	var sources []string
+	var targets []string
+	var relationships []string
+
+	var isNode = map[string]bool{}
+	for _, entity := range entities {
+		entityId, _ := entity["id"].(string)
+		isNode[entityId] = true
+	}
+	//Targets of relationships that are not part of the entities, with their type when the broker sends it
+	var targetTypes = map[string]string{}
+	var targetIds []string
+
+	// Range over entities, each entity is a node
+	for _, entity := range entities {
+		entityId, _ := entity["id"].(string)
+		nodeIds = append(nodeIds, entityId)
+		titles = append(titles, listString(entity["type"]))
+		mainStats.append(firstInstanceValue(entity, qm.MapMetric))
+
+		// Range over attributes, each target of a relationship is an edge
+		for _, k := range attributeNames(entity) {
+			for _, instance := range attributeInstances(entity[k]) {
+				relationship := k
+				if datasetId, _ := instance["datasetId"].(string); datasetId != "" {
+					relationship = k + " (" + datasetId + ")"
+				}
+				for _, target := range relationshipObjects(instance) {
+					edgeIds = append(edgeIds, entityId+" "+relationship+" "+target)
+					sources = append(sources, entityId)
+					targets = append(targets, target)
+					relationships = append(relationships, relationship)
+
+					if !isNode[target] {
+						if _, found := targetTypes[target]; !found {
+							targetIds = append(targetIds, target)
+							targetTypes[target] = ""
+						}
+						if objectType := listString(instance["objectType"]); objectType != "" {
+							targetTypes[target] = objectType
+						}
+					}
+				}
+			}
+		}
+	}
+
+	//The targets are nodes too, otherwise the panel does not display the edges leading to them
+	for _, target := range targetIds {
+		nodeIds = append(nodeIds, target)
+		titles = append(titles, targetTypes[target])
+		mainStats.append(nil, "")
+	}
+
+	nodes := data.NewFrame("nodes",
+		data.NewField("id", nil, nodeIds),
+		data.NewField("title", nil, titles),
+		data.NewField("subtitle", nil, nodeIds),
+	)
+	if qm.MapMetric != "" {
+		nodes.Fields = append(nodes.Fields, mainStats.fields()...)
+	}
+	edges := data.NewFrame("edges",
+		data.NewField("id", nil, edgeIds),
+		data.NewField("source", nil, sources),
+		data.NewField("target", nil, targets),
+		data.NewField("mainStat", nil, relationships),
+	)
+
+	response.Frames = append(response.Frames, nodes, edges)
+	return response
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"path"
+	"sync"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// Number of notifications kept for a live stream while its previous notifications are sent to Grafana
+const notificationsBufferSize = 16
+
+// Notification sent by the broker for a subscription
+type notification struct {
+	Id             string            `json:"id"`
+	SubscriptionId string            `json:"subscriptionId"`
+	Data           []json.RawMessage `json:"data"`
+}
+
+// HTTP server receiving the notifications of the subscriptions of the live streams.
+// Each stream has its own endpoint, the last segment of the path is the token of the stream.
+type notificationReceiver struct {
+	server  *http.Server
+	mu      sync.Mutex
+	streams map[string]chan []byte
+}
+
+// Start receiving notifications on the address
+func startNotificationReceiver(address string) (*notificationReceiver, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+	receiver := &notificationReceiver{streams: map[string]chan []byte{}}
+	receiver.server = &http.Server{Addr: address, Handler: receiver}
+	go func() {
+		if err := receiver.server.Serve(listener); err != http.ErrServerClosed {
+			log.DefaultLogger.Error("notification receiver stopped", "address", address, "err", err)
+		}
+	}()
+	log.DefaultLogger.Info("receiving notifications", "address", address)
+	return receiver, nil
+}
+
+// Register a stream and return its token, and the channel of the entities notified to the stream
+func (n *notificationReceiver) register() (string, <-chan []byte, error) {
+	random := make([]byte, 16)
+	if _, err := rand.Read(random); err != nil {
+		return "", nil, err
+	}
+	token := hex.EncodeToString(random)
+	entities := make(chan []byte, notificationsBufferSize)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	n.streams[token] = entities
+	return token, entities, nil
+}
+
+// Stop receiving the notifications of a stream, and return the number of streams still registered
+func (n *notificationReceiver) unregister(token string) int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	delete(n.streams, token)
+	return len(n.streams)
+}
+
+// Stop listening, the notifications being received are dropped
+func (n *notificationReceiver) close() {
+	if err := n.server.Close(); err != nil {
+		log.DefaultLogger.Warn("unable to close the notification receiver", "err", err)
+	}
+	log.DefaultLogger.Info("notification receiver closed", "address", n.server.Addr)
+}
+
+// Receive a notification and pass the notified entities to the stream
+func (n *notificationReceiver) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		writeResourceError(w, http.StatusMethodNotAllowed, errors.New("only POST is allowed"))
+		return
+	}
+	n.mu.Lock()
+	entities, found := n.streams[path.Base(r.URL.Path)]
+	n.mu.Unlock()
+	//The stream is stopped, the broker can deactivate the subscription
+	if !found {
+		writeResourceError(w, http.StatusNotFound, errors.New("unknown notification endpoint"))
+		return
+	}
+
+	var received notification
+	if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+		writeResourceError(w, http.StatusBadRequest, errors.New("a notification is expected"))
+		return
+	}
+	body, err := json.Marshal(received.Data)
+	if err != nil {
+		writeResourceError(w, http.StatusBadRequest, err)
+		return
+	}
+	//The broker is not slowed down by a stream that can't send its frames fast enough
+	select {
+	case entities <- body:
+	default:
+		log.DefaultLogger.Warn("notification dropped, the live stream is too slow", "subscription", received.SubscriptionId)
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
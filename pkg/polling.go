@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Poller running the query of a live channel at regular intervals, shared by all the streams of the channel
+type poller struct {
+	//Frames of the changed entities are sent to each stream, guarded by the mutex of the live streams
+	streams map[chan data.Frames]bool
+	//Done when the last stream leaves or the datasource instance is disposed
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Send the entities changed since the previous run of the query of a live channel to the stream,
+// until the context of the stream is done
+func (l *liveStreams) poll(ctx context.Context, instSetting *instanceSettings, path string, qm queryModel, sender *backend.StreamSender) error {
+	changes, leave := l.joinPoller(instSetting, path, qm)
+	defer leave()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case frames := <-changes:
+			for _, frame := range frames {
+				if err := sender.SendFrame(frame, data.IncludeAll); err != nil {
+					return err
+				}
+			}
+		}
+	}
+}
+
+// Join the poller of a live channel, it is started by the first stream and stopped when the last one leaves.
+// Return the channel of the frames of the changed entities, and the function to call to leave the poller.
+func (l *liveStreams) joinPoller(instSetting *instanceSettings, path string, qm queryModel) (<-chan data.Frames, func()) {
+	key := instSetting.uid + "/" + path
+	changes := make(chan data.Frames, notificationsBufferSize)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p, found := l.pollers[key]
+	//The poller of a disposed instance is left to its last streams, which are stopping
+	if !found || p.ctx.Err() != nil {
+		p = &poller{streams: map[chan data.Frames]bool{}}
+		p.ctx, p.cancel = context.WithCancel(instSetting.disposed)
+		l.pollers[key] = p
+		go l.runPoller(p.ctx, p, qm, instSetting)
+		log.DefaultLogger.Debug("live poller started", "path", path, "interval", instSetting.pollInterval)
+	}
+	p.streams[changes] = true
+
+	return changes, func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(p.streams, changes)
+		if len(p.streams) == 0 {
+			p.cancel()
+			if l.pollers[key] == p {
+				delete(l.pollers, key)
+			}
+			log.DefaultLogger.Debug("live poller stopped", "path", path)
+		}
+	}
+}
+
+// Run the query at each interval and send the changed entities to the streams of the poller.
+// The first run only takes the snapshot of the entities, the panels already got them from the query.
+func (l *liveStreams) runPoller(ctx context.Context, p *poller, qm queryModel, instSetting *instanceSettings) {
+	ticker := time.NewTicker(instSetting.pollInterval)
+	defer ticker.Stop()
+
+	var snapshot map[string]string
+	for {
+		entities, err := pollEntities(ctx, qm, instSetting)
+		if err == nil {
+			var changed []byte
+			changed, snapshot, err = changedEntities(entities, snapshot)
+			if err == nil && changed != nil {
+				response := transformEntities(ctx, qm, changed, instSetting, backend.DataResponse{})
+				err = response.Error
+				if err == nil {
+					l.broadcast(p, response.Frames)
+				}
+			}
+		}
+		if err != nil && ctx.Err() == nil {
+			log.DefaultLogger.Warn("unable to poll the entities of a live query", "err", err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Send the frames to all the streams of the poller
+func (l *liveStreams) broadcast(p *poller, frames data.Frames) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for changes := range p.streams {
+		//A stream that can't send its frames fast enough does not block the others
+		select {
+		case changes <- frames:
+		default:
+			log.DefaultLogger.Warn("changes dropped, the live stream is too slow")
+		}
+	}
+}
+
+// Get the entities of the query, the polls wait for their turn like the queries of the datasource
+func pollEntities(ctx context.Context, qm queryModel, instSetting *instanceSettings) ([]byte, error) {
+	select {
+	case instSetting.querySlots <- struct{}{}:
+		defer func() { <-instSetting.querySlots }()
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+	entities, _, err := getEntities(ctx, qm, instSetting)
+	return entities, err
+}
+
+// Compare the entities to the snapshot of the previous poll, and return the new and modified entities
+// with the snapshot of this poll. No entity is returned without previous snapshot.
+func changedEntities(entitiesByte []byte, previous map[string]string) ([]byte, map[string]string, error) {
+	var entities []map[string]interface{}
+	if err := json.Unmarshal(entitiesByte, &entities); err != nil {
+		return nil, previous, err
+	}
+
+	var snapshot = map[string]string{}
+	var changed []map[string]interface{}
+	for _, entity := range entities {
+		id, _ := entity["id"].(string)
+		snapshot[id] = entityVersion(entity)
+		if previous != nil && previous[id] != snapshot[id] {
+			changed = append(changed, entity)
+		}
+	}
+	if len(changed) == 0 {
+		return nil, snapshot, nil
+	}
+	changedByte, err := json.Marshal(changed)
+	return changedByte, snapshot, err
+}
+
+// Return the version of an entity : the last modification date of the entity or of its attributes,
+// or its content when the broker does not send the modification dates
+func entityVersion(entity map[string]interface{}) string {
+	latest := parseTime(entity["modifiedAt"])
+	for _, name := range attributeNames(entity) {
+		for _, instance := range attributeInstances(entity[name]) {
+			if modifiedAt := parseTime(instance["modifiedAt"]); modifiedAt != nil && (latest == nil || modifiedAt.After(*latest)) {
+				latest = modifiedAt
+			}
+		}
+	}
+	if latest != nil {
+		return latest.Format(time.RFC3339Nano)
+	}
+	content, _ := json.Marshal(entity)
+	return string(content)
+}
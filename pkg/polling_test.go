@@ -0,0 +1,136 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChangedEntities(t *testing.T) {
+	entities := `[{"id":"urn:a","modifiedAt":"2022-01-01T00:00:00Z","temperature":{"type":"Property","value":20,"modifiedAt":"2022-01-01T00:00:00Z"}},` +
+		`{"id":"urn:b","temperature":{"type":"Property","value":20}}]`
+	snapshot := map[string]string{
+		"urn:a": "2022-01-01T00:00:00Z",
+		"urn:b": `{"id":"urn:b","temperature":{"type":"Property","value":20}}`,
+	}
+	tests := []struct {
+		name     string
+		entities string
+		previous map[string]string
+		want     string
+	}{
+		{name: "first snapshot", entities: entities, previous: nil},
+		{name: "no change", entities: entities, previous: snapshot},
+		{
+			name: "entity modified",
+			entities: `[{"id":"urn:a","modifiedAt":"2022-01-02T00:00:00Z","temperature":{"type":"Property","value":20,"modifiedAt":"2022-01-01T00:00:00Z"}},` +
+				`{"id":"urn:b","temperature":{"type":"Property","value":20}}]`,
+			previous: snapshot,
+			want:     `[{"id":"urn:a","modifiedAt":"2022-01-02T00:00:00Z","temperature":{"modifiedAt":"2022-01-01T00:00:00Z","type":"Property","value":20}}]`,
+		},
+		{
+			name: "attribute modified",
+			entities: `[{"id":"urn:a","modifiedAt":"2022-01-01T00:00:00Z","temperature":{"type":"Property","value":21,"modifiedAt":"2022-01-01T00:05:00Z"}},` +
+				`{"id":"urn:b","temperature":{"type":"Property","value":20}}]`,
+			previous: snapshot,
+			want:     `[{"id":"urn:a","modifiedAt":"2022-01-01T00:00:00Z","temperature":{"modifiedAt":"2022-01-01T00:05:00Z","type":"Property","value":21}}]`,
+		},
+		{
+			name: "content changed without modification dates",
+			entities: `[{"id":"urn:a","modifiedAt":"2022-01-01T00:00:00Z","temperature":{"type":"Property","value":20,"modifiedAt":"2022-01-01T00:00:00Z"}},` +
+				`{"id":"urn:b","temperature":{"type":"Property","value":22}}]`,
+			previous: snapshot,
+			want:     `[{"id":"urn:b","temperature":{"type":"Property","value":22}}]`,
+		},
+		{
+			name: "new entity",
+			entities: `[{"id":"urn:a","modifiedAt":"2022-01-01T00:00:00Z","temperature":{"type":"Property","value":20,"modifiedAt":"2022-01-01T00:00:00Z"}},` +
+				`{"id":"urn:c","modifiedAt":"2022-01-01T00:00:00Z"}]`,
+			previous: snapshot,
+			want:     `[{"id":"urn:c","modifiedAt":"2022-01-01T00:00:00Z"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			changed, next, err := changedEntities([]byte(tt.entities), tt.previous)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(changed) != tt.want {
+				t.Errorf("changedEntities() = %s, want %s", changed, tt.want)
+			}
+			if len(next) != 2 {
+				t.Errorf("snapshot = %v, want the version of each entity", next)
+			}
+		})
+	}
+
+	if _, previous, err := changedEntities([]byte(`{`), snapshot); err == nil || len(previous) != len(snapshot) {
+		t.Errorf("changedEntities() of an invalid response = %v, %v, want an error and the previous snapshot", previous, err)
+	}
+}
+
+func TestJoinPoller(t *testing.T) {
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer broker.Close()
+	instSetting := newTestInstance(t, map[string]interface{}{"contextBrokerUrl": broker.URL, "pollInterval": 3600})
+	l := newLiveStreams()
+	qm := queryModel{EntityType: "Sensor"}
+
+	_, leaveFirst := l.joinPoller(instSetting, "entities/1", qm)
+	_, leaveSecond := l.joinPoller(instSetting, "entities/1", qm)
+	_, leaveOther := l.joinPoller(instSetting, "entities/2", qm)
+	defer leaveOther()
+
+	l.mu.Lock()
+	p := l.pollers[instSetting.uid+"/entities/1"]
+	if len(l.pollers) != 2 || p == nil || len(p.streams) != 2 {
+		t.Fatalf("pollers = %v, want one poller per path shared by its streams", l.pollers)
+	}
+	l.mu.Unlock()
+
+	leaveFirst()
+	if p.ctx.Err() != nil {
+		t.Error("the poller stopped while a stream still runs")
+	}
+	leaveSecond()
+	if p.ctx.Err() == nil {
+		t.Error("the poller still runs after its last stream left")
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if _, found := l.pollers[instSetting.uid+"/entities/1"]; found || len(l.pollers) != 1 {
+		t.Errorf("pollers = %v, want only the poller of the other path", l.pollers)
+	}
+}
+
+func TestJoinPollerOfDisposedInstance(t *testing.T) {
+	broker := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`[]`))
+	}))
+	defer broker.Close()
+	l := newLiveStreams()
+	qm := queryModel{EntityType: "Sensor"}
+
+	disposed := newTestInstance(t, map[string]interface{}{"contextBrokerUrl": broker.URL, "pollInterval": 3600})
+	_, leaveDisposed := l.joinPoller(disposed, "entities/1", qm)
+	l.mu.Lock()
+	old := l.pollers[disposed.uid+"/entities/1"]
+	l.mu.Unlock()
+	disposed.Dispose()
+
+	//The stream of the new instance does not join the stopped poller of the disposed one
+	current := newTestInstance(t, map[string]interface{}{"contextBrokerUrl": broker.URL, "pollInterval": 3600})
+	_, leaveCurrent := l.joinPoller(current, "entities/1", qm)
+	defer leaveCurrent()
+	leaveDisposed()
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	p := l.pollers[current.uid+"/entities/1"]
+	if p == nil || p == old || p.ctx.Err() != nil {
+		t.Errorf("poller of the new instance = %+v, want a running poller replacing the disposed one", p)
+	}
+}
@@ -0,0 +1,151 @@
+package main
+
+import (
+	"encoding/json"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+// Members of an entity that are always kept, the transforms need them to identify the entities
+var entityIdentityMembers = map[string]bool{
+	"id":       true,
+	"type":     true,
+	"@context": true,
+}
+
+// Add the projection parameters to the query parameters sent to the broker :
+// attrs is understood by all brokers, pick and omit only since NGSI-LD 1.8
+func setProjectionParams(q url.Values, qm queryModel, instSetting *instanceSettings) {
+	if attrs := projectedAttributes(qm); len(attrs) > 0 {
+		q.Set("attrs", strings.Join(attrs, ","))
+	}
+	if !supportsNgsiLdVersion(instSetting, 1, 8) {
+		return
+	}
+	//id and type are members like the attributes for pick, they must be kept for the transforms
+	if pick := splitList(qm.Pick); len(pick) > 0 {
+		pick = append([]string{"id", "type"}, pick...)
+		q.Set("pick", strings.Join(append(pick, requiredAttributes(qm)...), ","))
+	}
+	if omit := omittedAttributes(qm); len(omit) > 0 {
+		q.Set("omit", strings.Join(omit, ","))
+	}
+}
+
+// Keep only the selected attributes of the entities. The broker already did it when it supports the projection
+// parameters, but it makes the table and map transforms consistent whatever the broker.
+func applyProjection(entitiesByte []byte, qm queryModel) ([]byte, error) {
+	attrs := projectedAttributes(qm)
+	pick := splitList(qm.Pick)
+	omit := omittedAttributes(qm)
+	if len(attrs) == 0 && len(pick) == 0 && len(omit) == 0 {
+		return entitiesByte, nil
+	}
+
+	hasSelection := len(attrs) > 0 || len(pick) > 0
+	var kept = map[string]bool{}
+	for _, attribute := range append(append(attrs, pick...), requiredAttributes(qm)...) {
+		kept[attribute] = true
+	}
+	var omitted = map[string]bool{}
+	for _, attribute := range omit {
+		omitted[attribute] = true
+	}
+
+	var entities []map[string]interface{}
+	if err := json.Unmarshal(entitiesByte, &entities); err != nil {
+		return nil, err
+	}
+	for _, entity := range entities {
+		for k, v := range entity {
+			if entityIdentityMembers[k] || !isAttribute(v) {
+				continue
+			}
+			if (hasSelection && !kept[k]) || omitted[k] {
+				delete(entity, k)
+			}
+		}
+	}
+	return json.Marshal(entities)
+}
+
+// Tell if an entity member is an attribute (a single instance or a list of instances),
+// and not a member like createdAt or scope
+func isAttribute(member interface{}) bool {
+	switch v := member.(type) {
+	case map[string]interface{}:
+		return true
+	case []interface{}:
+		if len(v) > 0 {
+			_, isInstance := v[0].(map[string]interface{})
+			return isInstance
+		}
+	}
+	return false
+}
+
+// Return the attributes asked by the user, with the ones needed by the output format
+func projectedAttributes(qm queryModel) []string {
+	attrs := splitList(qm.Attrs)
+	if len(attrs) == 0 {
+		return nil
+	}
+	return append(attrs, requiredAttributes(qm)...)
+}
+
+// Return the attributes that can't be left out of the entities, because the output format uses them
+// or because they are the relationships to follow
+func requiredAttributes(qm queryModel) []string {
+	required := splitList(qm.Relationships)
+	if qm.Format != "worldmap" && qm.Format != "geomap" {
+		return required
+	}
+	required = append(required, mapGeoproperty(qm))
+	if qm.MapMetric != "" {
+		required = append(required, qm.MapMetric)
+	}
+	return required
+}
+
+// Return the attributes the user chose to leave out, except the required ones
+func omittedAttributes(qm queryModel) []string {
+	var required = map[string]bool{}
+	for _, attribute := range requiredAttributes(qm) {
+		required[attribute] = true
+	}
+	var omitted []string
+	for _, attribute := range splitList(qm.Omit) {
+		if !required[attribute] {
+			omitted = append(omitted, attribute)
+		}
+	}
+	return omitted
+}
+
+// Split a comma separated list, ignoring the spaces and the empty elements
+func splitList(list string) []string {
+	var elements []string
+	for _, element := range strings.Split(list, ",") {
+		if element = strings.TrimSpace(element); element != "" {
+			elements = append(elements, element)
+		}
+	}
+	return elements
+}
+
+// Tell if the broker implements at least the given version of the NGSI-LD API.
+// The version is configured in the datasource, the version 1.6 is assumed when it is not.
+func supportsNgsiLdVersion(instSetting *instanceSettings, major int, minor int) bool {
+	version := instSetting.apiVersion
+	if version == "" {
+		version = defaultNgsiLdVersion
+	}
+	parts := strings.SplitN(version, ".", 2)
+	brokerMajor, _ := strconv.Atoi(parts[0])
+	brokerMinor := 0
+	if len(parts) > 1 {
+		brokerMinor, _ = strconv.Atoi(parts[1])
+	}
+	return brokerMajor > major || (brokerMajor == major && brokerMinor >= minor)
+}
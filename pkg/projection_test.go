@@ -0,0 +1,98 @@
+package main
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestApplyProjection(t *testing.T) {
+	entities := `[{"id":"urn:a","type":"Sensor","location":{"type":"GeoProperty","value":{"type":"Point","coordinates":[5,45]}},` +
+		`"temperature":{"type":"Property","value":20},"humidity":{"type":"Property","value":50},` +
+		`"isIn":{"type":"Relationship","object":"urn:b"}}]`
+	tests := []struct {
+		name string
+		qm   queryModel
+		want string
+	}{
+		{
+			name: "no projection",
+			qm:   queryModel{},
+			want: entities,
+		},
+		{
+			name: "attributes",
+			qm:   queryModel{Attrs: "temperature"},
+			want: `[{"id":"urn:a","temperature":{"type":"Property","value":20},"type":"Sensor"}]`,
+		},
+		{
+			name: "omitted attributes with spaces",
+			qm:   queryModel{Omit: "humidity, isIn"},
+			want: `[{"id":"urn:a","location":{"type":"GeoProperty","value":{"coordinates":[5,45],"type":"Point"}},"temperature":{"type":"Property","value":20},"type":"Sensor"}]`,
+		},
+		{
+			name: "omitted geoproperty and metric of a map",
+			qm:   queryModel{Format: "geomap", MapMetric: "temperature", Omit: "location,temperature,humidity"},
+			want: `[{"id":"urn:a","isIn":{"object":"urn:b","type":"Relationship"},"location":{"type":"GeoProperty","value":{"coordinates":[5,45],"type":"Point"}},"temperature":{"type":"Property","value":20},"type":"Sensor"}]`,
+		},
+		{
+			name: "omitted followed relationship",
+			qm:   queryModel{Relationships: "isIn", Omit: "isIn,location"},
+			want: `[{"humidity":{"type":"Property","value":50},"id":"urn:a","isIn":{"object":"urn:b","type":"Relationship"},"temperature":{"type":"Property","value":20},"type":"Sensor"}]`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projected, err := applyProjection([]byte(entities), tt.qm)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(projected) != tt.want {
+				t.Errorf("applyProjection() = %s, want %s", projected, tt.want)
+			}
+		})
+	}
+}
+
+func TestSetProjectionParams(t *testing.T) {
+	tests := []struct {
+		name       string
+		apiVersion string
+		qm         queryModel
+		want       url.Values
+	}{
+		{
+			name:       "omit without spaces",
+			apiVersion: "1.8",
+			qm:         queryModel{Omit: "a, b"},
+			want:       url.Values{"omit": {"a,b"}},
+		},
+		{
+			name:       "required attributes are not omitted",
+			apiVersion: "1.8",
+			qm:         queryModel{Format: "worldmap", MapMetric: "temperature", Omit: "temperature, location, humidity"},
+			want:       url.Values{"omit": {"humidity"}},
+		},
+		{
+			name:       "pick with the identity and required attributes",
+			apiVersion: "1.8",
+			qm:         queryModel{Relationships: "isIn", Pick: "name"},
+			want:       url.Values{"pick": {"id,type,name,isIn"}},
+		},
+		{
+			name:       "no pick and omit before NGSI-LD 1.8",
+			apiVersion: "1.6",
+			qm:         queryModel{Attrs: "name", Omit: "a"},
+			want:       url.Values{"attrs": {"name"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			instSetting := newTestInstance(t, map[string]interface{}{"apiVersion": tt.apiVersion})
+			q := url.Values{}
+			setProjectionParams(q, tt.qm, instSetting)
+			if q.Encode() != tt.want.Encode() {
+				t.Errorf("setProjectionParams() = %s, want %s", q.Encode(), tt.want.Encode())
+			}
+		})
+	}
+}
@@ -4,7 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"fmt"
-	"strings"
+	"sync"
 	"time"
 
 	"github.com/grafana/grafana-plugin-sdk-go/backend"
@@ -14,6 +14,21 @@ import (
 	"github.com/grafana/grafana-plugin-sdk-go/data"
 )
 
+const (
+	// Number of entities asked to the broker for each page
+	defaultPageSize = 100
+	// Maximum number of entities fetched for a single query
+	defaultMaxEntities = 10000
+	// Maximum number of queries of a datasource sent to the broker at the same time
+	defaultMaxConcurrentQueries = 4
+	// Version of the NGSI-LD API assumed when the datasource does not tell it
+	defaultNgsiLdVersion = "1.6"
+	// Timeout in seconds of each HTTP call to the broker or to the auth server
+	defaultTimeout = 30
+	// Interval in seconds between two runs of a live query, when the broker can't notify the changes
+	defaultPollInterval = 10
+)
+
 // newDatasource returns datasource.ServeOpts.
 func newDatasource() datasource.ServeOpts {
 	// creates a instance manager for your plugin. The function passed
@@ -21,12 +36,15 @@ func newDatasource() datasource.ServeOpts {
 	// for the first time or when a datasource configuration changed.
 	im := datasource.NewInstanceManager(newDataSourceInstance)
 	ds := &SampleDatasource{
-		im: im,
+		im:   im,
+		live: newLiveStreams(),
 	}
 
 	return datasource.ServeOpts{
-		QueryDataHandler:   ds,
-		CheckHealthHandler: ds,
+		QueryDataHandler:    ds,
+		CheckHealthHandler:  ds,
+		CallResourceHandler: newResourceHandler(ds),
+		StreamHandler:       ds,
 	}
 }
 
@@ -37,6 +55,9 @@ type SampleDatasource struct {
 	// of datasource instances in plugins. It's not a requirements
 	// but a best practice that we recommend that you follow.
 	im instancemgmt.InstanceManager
+
+	// The live queries, and the receivers of the notifications of their subscriptions
+	live *liveStreams
 }
 
 // QueryData handles multiple queries and returns multiple responses.
@@ -53,21 +74,49 @@ func (td *SampleDatasource) QueryData(ctx context.Context, req *backend.QueryDat
 	}
 	instSetting, _ := instance.(*instanceSettings)
 
-	//Get token with settings param (url, resource, client_id, client_secret)
-	token := getToken(instSetting)
-
-	// loop over queries and execute them individually.
+	// execute the queries concurrently, the number of queries running at the
+	// same time on the broker is bounded by the datasource instance.
+	var wg sync.WaitGroup
+	var mu sync.Mutex
 	for _, q := range req.Queries {
-		res := td.query(ctx, q, instSetting, token)
+		wg.Add(1)
+		go func(q backend.DataQuery) {
+			defer wg.Done()
+
+			var res backend.DataResponse
+			select {
+			case instSetting.querySlots <- struct{}{}:
+				res = td.safeQuery(ctx, q, instSetting)
+				<-instSetting.querySlots
+			case <-ctx.Done():
+				// the request was cancelled while the query was waiting for its turn
+				res = backend.DataResponse{Error: ctx.Err()}
+			}
 
-		// save the response in a hashmap
-		// based on with RefID as identifier
-		response.Responses[q.RefID] = res
+			// save the response in a hashmap
+			// based on with RefID as identifier
+			mu.Lock()
+			response.Responses[q.RefID] = res
+			mu.Unlock()
+		}(q)
 	}
+	wg.Wait()
 	return response, nil
 }
 
-func (td *SampleDatasource) query(ctx context.Context, query backend.DataQuery, instSetting *instanceSettings, token string) backend.DataResponse {
+// Run the query, a panic while handling an unexpected broker response is returned as the query error
+// instead of stopping the plugin.
+func (td *SampleDatasource) safeQuery(ctx context.Context, query backend.DataQuery, instSetting *instanceSettings) (response backend.DataResponse) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.DefaultLogger.Error("query failed", "refId", query.RefID, "panic", r)
+			response = backend.DataResponse{Error: fmt.Errorf("unable to handle the context broker response: %v", r)}
+		}
+	}()
+	return td.query(ctx, query, instSetting)
+}
+
+func (td *SampleDatasource) query(ctx context.Context, query backend.DataQuery, instSetting *instanceSettings) backend.DataResponse {
 	// Unmarshal the json into our queryModel
 	var qm queryModel
 	response := backend.DataResponse{}
@@ -77,165 +126,223 @@ func (td *SampleDatasource) query(ctx context.Context, query backend.DataQuery,
 		return response
 	}
 
-	var entity []byte
-	if qm.EntityId != "" {
-		entity = getEntityById(qm.EntityId, qm.Context, token, instSetting)
-	} else {
-		entity = getEntitesByType(qm.EntityType, qm.ValueFilterQuery, qm.Context, token, instSetting)
+	//The tenant of the query overrides the default tenant of the datasource
+	if qm.Tenant == "" {
+		qm.Tenant = instSetting.tenant
+	}
+	//The context of the dashboard variable overrides the default context of the datasource,
+	//the queries run without dashboard (alert rules) use the default context
+	if qm.Context == "" {
+		qm.Context = instSetting.context
 	}
 
-	if qm.Format == "worldmap" {
-		worldMapResponse := transformToWorldMap(qm, entity, response)
-		return worldMapResponse
-	} else {
-		tableResponse := transformToTable(qm, entity, response)
-		return tableResponse
+	//Check the geo-query before sending it, the broker answers are not always explicit
+	qm.Georel, qm.Coordinates, response.Error = validateGeoQuery(qm)
+	if response.Error != nil {
+		return response
+	}
+	response.Error = validateScopeQuery(qm.ScopeQuery)
+	if response.Error != nil {
+		return response
+	}
+	response.Error = validateTypeQuery(qm.EntityType)
+	if response.Error != nil {
+		return response
+	}
+	qm.JoinLevel, response.Error = validateJoinLevel(qm)
+	if response.Error != nil {
+		return response
+	}
+	if qm.Live {
+		response.Error = validateLiveQuery(qm, instSetting)
+		if response.Error != nil {
+			return response
+		}
+	}
+
+	//Temporal queries give the evolution of the attributes over the dashboard time range
+	if qm.QueryMode == "temporal" {
+		aggregated := len(splitList(qm.AggrMethods)) > 0
+		if aggregated {
+			response.Error = validateAggrMethods(qm.AggrMethods)
+			if response.Error != nil {
+				return response
+			}
+		}
+		entities, pages, err := getTemporalEntities(ctx, qm, query.TimeRange, instSetting)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		if aggregated {
+			response = transformAggregatedToTimeSeries(qm, entities, response)
+		} else {
+			response = transformToTimeSeries(qm, entities, response)
+		}
+		if response.Error == nil {
+			addPaginationMeta(response.Frames, pages, instSetting)
+		}
+		return response
+	}
+
+	//Variable queries list values found in the entities, only the listed attribute is needed
+	if qm.QueryMode == "variable" {
+		qm.VariableType, response.Error = validateVariableQuery(qm)
+		if response.Error != nil {
+			return response
+		}
+		if qm.VariableType == "attributeValues" && qm.Attrs == "" {
+			qm.Attrs = qm.MapMetric
+		}
+	}
+
+	entity, pages, err := getEntities(ctx, qm, instSetting)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	response = transformEntities(ctx, qm, entity, instSetting, response)
+	if response.Error != nil {
+		return response
+	}
+	addPaginationMeta(response.Frames, pages, instSetting)
+
+	//The panels of a live query subscribe to its channel to receive the changes of the entities
+	if qm.Live {
+		channel, err := td.live.channel(instSetting, qm)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+		addLiveChannel(response.Frames, channel)
+	}
+	return response
+}
+
+// Return a DataResponse with the entities in the format of the query, the entities of the query result
+// or the entities notified to a live stream.
+// The projection is applied and the attributes of the linked entities are added first.
+func transformEntities(ctx context.Context, qm queryModel, entitiesByte []byte, instSetting *instanceSettings, response backend.DataResponse) backend.DataResponse {
+	entitiesByte, err := applyProjection(entitiesByte, qm)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+	//The attributes of the linked entities are added after the projection, which only applies to the entities
+	if qm.Relationships != "" {
+		entitiesByte, err = addLinkedEntities(ctx, qm, entitiesByte, instSetting)
+		if err != nil {
+			response.Error = err
+			return response
+		}
+	}
+
+	switch {
+	case qm.QueryMode == "variable":
+		return transformToVariableValues(qm, entitiesByte, response)
+	case qm.Format == "worldmap":
+		return transformToWorldMap(qm, entitiesByte, response)
+	case qm.Format == "wide":
+		return transformToWide(qm, entitiesByte, response)
+	case qm.Format == "geomap":
+		return transformToGeomap(qm, entitiesByte, response)
+	case qm.Format == "nodegraph":
+		return transformToNodeGraph(qm, entitiesByte, response)
+	case qm.Format == "alerting":
+		return transformToAlerting(qm, entitiesByte, response)
+	default:
+		return transformToTable(qm, entitiesByte, response)
+	}
+}
+
+// Report the total number of entities on each frame, and warn the user when some entities are not displayed
+func addPaginationMeta(frames data.Frames, pages pagination, instSetting *instanceSettings) {
+	for _, frame := range frames {
+		if pages.resultsCount > 0 {
+			if frame.Meta == nil {
+				frame.Meta = &data.FrameMeta{}
+			}
+			frame.Meta.Custom = map[string]interface{}{"resultsCount": pages.resultsCount}
+		}
+		if pages.truncated {
+			text := fmt.Sprintf("Only the first %d entities are displayed, the maximum number of entities can be changed in the datasource settings", instSetting.maxEntities)
+			if pages.resultsCount > 0 {
+				text = fmt.Sprintf("Only the first %d of %d entities are displayed, the maximum number of entities can be changed in the datasource settings", instSetting.maxEntities, pages.resultsCount)
+			}
+			//The broker stopped sending pages before the maximum number of entities was reached
+			if pages.fetched < instSetting.maxEntities && pages.resultsCount > 0 {
+				text = fmt.Sprintf("Only %d of %d entities were sent by the context broker", pages.fetched, pages.resultsCount)
+			}
+			frame.AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     text,
+			})
+		}
+		if pages.partialValues {
+			text := "The context broker only sent a part of the temporal values, reduce the time range to get all of them"
+			if pages.partialRange != "" {
+				text = fmt.Sprintf("The context broker only sent the temporal values of the range %s, reduce the time range to get all of them", pages.partialRange)
+			}
+			frame.AppendNotices(data.Notice{
+				Severity: data.NoticeSeverityWarning,
+				Text:     text,
+			})
+		}
 	}
 }
 
 // Return a DataResponse to display data in table view
-//(The dataResponse contains a frame with 5 fields : attributes, metrics, multiAttributeValues, createdAt, modifiedAt)
+// (The dataResponse contains a frame with one row per attribute instance : attribute, value, unit, createdAt, modifiedAt)
 func transformToTable(qm queryModel, entitiesByte []byte, response backend.DataResponse) backend.DataResponse {
-	var entityId = qm.EntityId
 	var metadataSelector = qm.MetadataSelector
 	var hasMetadataSelector = metadataSelector != ""
 
 	// create data frame response
-	frame := data.NewFrame(entityId)
+	frame := data.NewFrame(qm.EntityId)
 
 	//Store each value on a slice
 	var attributes []string
-	var metrics []string
-	var createdAt []string
-	var modifiedAt []string
-	var multiAttributeValues []string
-
-	var entities []interface{}
-	json.Unmarshal(entitiesByte, &entities)
+	var values = newValueColumn("Value")
+	var multiAttributeValues = newValueColumn(metadataSelector)
+	var unitCodes []string
+	var createdAt []*time.Time
+	var modifiedAt []*time.Time
+	var scopes []string
+	var hasUnitCode = false
+	var hasScope = false
+
+	var entities []map[string]interface{}
+	err := json.Unmarshal(entitiesByte, &entities)
+	if err != nil {
+		response.Error = err
+		return response
+	}
 
 	// Range over entities
-	for entity := 0; entity < len(entities); entity++ {
-		var foundMetadataSelector = false
-		entityInterface := entities[entity].(map[string]interface{})
-		// Range over attributes
-		for k, v := range entityInterface {
-			switch attribute := v.(type) {
-
-			case string: // Handle case where attribute value is string (id, type, createdAt...)
-			case []interface{}:
-				if k != "@context" {
-					//Range over attributes
-					for _, multiAttribute := range attribute {
-						var propertyInterface = multiAttribute.(map[string]interface{})
-						var currentValue string
-						var currentUnitCode string
-						var currentCreatedAt string
-						var currentModifiedAt string
-						//Range over properties
-						for propertyKey, propertyValue := range propertyInterface {
-							//We get the value if it's a Property or object if it's a Relationship
-							if propertyKey == "value" || propertyKey == "object" {
-								currentValue = fmt.Sprintf("%v", propertyValue)
-							}
-							if propertyKey == "unitCode" {
-								currentUnitCode = fmt.Sprintf("%v", propertyValue)
-							}
-							if propertyKey == "createdAt" {
-								currentCreatedAt = fmt.Sprintf("%v", propertyValue)
-							}
-							if propertyKey == "modifiedAt" {
-								currentModifiedAt = fmt.Sprintf("%v", propertyValue)
-							}
-							//Getting metadataSelector value and unitCode
-							if propertyKey == metadataSelector {
-								foundMetadataSelector = true
-								var metadataSelectorPropertyInterface = propertyValue.(map[string]interface{})
-								var metadataSelectorValueString = fmt.Sprintf("%v", metadataSelectorPropertyInterface["value"])
-								var metadataSelectorUnitCodeString = fmt.Sprintf("%v", metadataSelectorPropertyInterface["unitCode"])
-
-								var multiAttributeValue = buildString("", metadataSelectorValueString, metadataSelectorUnitCodeString, "", "", "")
-								multiAttributeValues = append(multiAttributeValues, multiAttributeValue)
-							}
-						}
-						//If current attribute don't have the metadataSelector
-						if metadataSelector != "" && !foundMetadataSelector {
-							multiAttributeValues = append(multiAttributeValues, "")
-						}
-
-						attributes = append(attributes, k)
-						createdAt = append(createdAt, dateFormat(currentCreatedAt))
-						modifiedAt = append(modifiedAt, dateFormat(currentModifiedAt))
-						metrics = append(metrics, buildString("", currentValue, currentUnitCode, "", "", ""))
-					}
-				}
-
-			case interface{}:
-				var attributeValueInterface = attribute.(map[string]interface{})["value"]
-
-				//If key is "location"
-				if k == "location" {
-					// convert map to json
-					jsonString, _ := json.Marshal(attributeValueInterface)
-					// convert json to struct
-					location := Location{}
-					json.Unmarshal(jsonString, &location)
-
-					coordinates := fmt.Sprintf("%f", location.Coordinates)
-					metrics = append(metrics, coordinates)
-					createdAt = append(createdAt, "")
-					modifiedAt = append(modifiedAt, "")
+	for _, entity := range entities {
+		//The scopes of the entity are displayed on each row of its attributes
+		var entityScope = listString(entity["scope"])
+		hasScope = hasScope || entityScope != ""
 
-					if hasMetadataSelector {
-						multiAttributeValues = append(multiAttributeValues, "")
-					}
-
-				} else {
-					var currentValue string
-					var currentUnitCode string
-					var currentCreatedAt string
-					var currentModifiedAt string
-
-					var propertyInterface = attribute.(map[string]interface{})
-					//Getting the property value and unitCode
-					for propertyKey, propertyValue := range propertyInterface {
-						//We get the value if it's a Property or object if it's a Relationship
-						if propertyKey == "value" || propertyKey == "object" {
-							currentValue = fmt.Sprintf("%v", propertyValue)
-						}
-						if propertyKey == "unitCode" {
-							currentUnitCode = fmt.Sprintf("%v", propertyValue)
-						}
-						if propertyKey == "createdAt" {
-							currentCreatedAt = fmt.Sprintf("%v", propertyValue)
-						}
-						if propertyKey == "modifiedAt" {
-							currentModifiedAt = fmt.Sprintf("%v", propertyValue)
-						}
-						//Getting metadataSelector value and unitCode
-						if propertyKey == metadataSelector {
-							foundMetadataSelector = true
-							var metadataSelectorPropertyInterface = propertyValue.(map[string]interface{})
-
-							var metadataSelectorValueString = fmt.Sprintf("%v", metadataSelectorPropertyInterface["value"])
-							var metadataSelectorUnitCodeString = fmt.Sprintf("%v", metadataSelectorPropertyInterface["unitCode"])
-
-							mutltiAttributeValue := buildString("", metadataSelectorValueString, metadataSelectorUnitCodeString, "", "", "")
-							multiAttributeValues = append(multiAttributeValues, mutltiAttributeValue)
-						}
-					}
-					//If current attribute don't have the metadataSelector
-					if metadataSelector != "" && !foundMetadataSelector {
-						multiAttributeValues = append(multiAttributeValues, "")
+		// Range over attributes
+		for _, k := range attributeNames(entity) {
+			for _, instance := range attributeInstances(entity[k]) {
+				attributes = append(attributes, k)
+				values.append(attributeValue(instance), unitCode(instance))
+				unitCodes = append(unitCodes, unitCode(instance))
+				hasUnitCode = hasUnitCode || unitCode(instance) != ""
+				createdAt = append(createdAt, parseTime(instance["createdAt"]))
+				modifiedAt = append(modifiedAt, parseTime(instance["modifiedAt"]))
+				scopes = append(scopes, entityScope)
+
+				//Getting metadataSelector value and unitCode
+				if hasMetadataSelector {
+					if subProperty, ok := instance[metadataSelector].(map[string]interface{}); ok {
+						multiAttributeValues.append(attributeValue(subProperty), unitCode(subProperty))
+					} else {
+						multiAttributeValues.append(nil, "")
 					}
-
-					metrics = append(metrics, buildString("", currentValue, currentUnitCode, "", "", ""))
-					createdAt = append(createdAt, dateFormat(currentCreatedAt))
-					modifiedAt = append(modifiedAt, dateFormat(currentModifiedAt))
 				}
-				attributes = append(attributes, k)
-
-			default:
-				log.DefaultLogger.Error(k, "is of a type I don't know how to handle")
 			}
 		}
 	}
@@ -243,20 +350,26 @@ func transformToTable(qm queryModel, entitiesByte []byte, response backend.DataR
 	frame.Fields = append(frame.Fields,
 		data.NewField("Attribute", nil, attributes),
 	)
-	frame.Fields = append(frame.Fields,
-		data.NewField("Value ", nil, metrics),
-	)
-	if hasMetadataSelector {
+	frame.Fields = append(frame.Fields, values.fields()...)
+	if hasUnitCode {
 		frame.Fields = append(frame.Fields,
-			data.NewField(metadataSelector, nil, multiAttributeValues),
+			data.NewField("Unit", nil, unitCodes),
 		)
 	}
+	if hasMetadataSelector {
+		frame.Fields = append(frame.Fields, multiAttributeValues.fields()...)
+	}
 	frame.Fields = append(frame.Fields,
 		data.NewField("Created at", nil, createdAt),
 	)
 	frame.Fields = append(frame.Fields,
 		data.NewField("Modified at", nil, modifiedAt),
 	)
+	if hasScope {
+		frame.Fields = append(frame.Fields,
+			data.NewField("Scope", nil, scopes),
+		)
+	}
 
 	// add the frames to the response
 	response.Frames = append(response.Frames, frame)
@@ -264,157 +377,67 @@ func transformToTable(qm queryModel, entitiesByte []byte, response backend.DataR
 }
 
 // Return a DataResponse to display data in map view
-//(The dataResponse contains a frame with 6 fields : entitiesId, attributes, metrics, latitudes, longitudes, multiAttributeValues)
+// (The dataResponse contains a frame with one row per located entity : id, attribute, metric, latitude, longitude
+// and the metadataSelector value of the metric)
 func transformToWorldMap(qm queryModel, entitiesByte []byte, response backend.DataResponse) backend.DataResponse {
-	var VALUES_SEPARATOR = ","
-	var entityId = qm.EntityId
 	var metadataSelector = qm.MetadataSelector
 	var mapMetric = qm.MapMetric
 	var hasMetadataSelector = metadataSelector != ""
+	var geoproperty = mapGeoproperty(qm)
 
 	// create data frame response
-	frame := data.NewFrame(entityId)
+	frame := data.NewFrame(qm.EntityId)
 	//Store each value on a slice
 	var entitiesId []string
 	var attributes []string
-	var metrics []string
+	var metrics = newValueColumn("metric")
 	var latitudes []float64
 	var longitudes []float64
-	var multiAttributeValues []string
+	var multiAttributeValues = newValueColumn(mapMetric + " (" + metadataSelector + ")")
 
-	var entities []interface{}
-	json.Unmarshal(entitiesByte, &entities)
+	var entities []map[string]interface{}
+	err := json.Unmarshal(entitiesByte, &entities)
+	if err != nil {
+		response.Error = err
+		return response
+	}
 
 	// Range over entities
-	for entity := 0; entity < len(entities); entity++ {
-		entityInterface := entities[entity].(map[string]interface{})
-		var foundAttribute = false
-		var hasLocation = false
-		var foundMetadataSelector = false
-		entityId = fmt.Sprintf("%v", entityInterface["id"])
-
-		// Range over attributes
-		for k, v := range entityInterface {
-			switch attribute := v.(type) {
-
-			case string: // Handle case where attribute value is string (id, type, createdAt...)
-			case []interface{}:
-				//We find the attribute
-				if k == mapMetric {
-					foundAttribute = true
-					var allMultiAttributeValues = ""
-					var currentValue string
-					var currentUnitCode string
-					var currentMetadataSelectorValue string
-					var currentMetadataSelectorUnitCode string
-					//Range over properties
-					for _, multiAttribute := range attribute {
-						var propertyInterface = multiAttribute.(map[string]interface{})
-						//Getting the property value and unitCode
-						for propertyKey, propertyValue := range propertyInterface {
-							//We get the value if it's a Property or object if it's a Relationship
-							if propertyKey == "value" || propertyKey == "object" {
-								currentValue = fmt.Sprintf("%v", propertyValue)
-							}
-							if propertyKey == "unitCode" {
-								currentUnitCode = fmt.Sprintf("%v", propertyValue)
-							}
-							//Getting metadataSelector value and unitCode
-							if propertyKey == metadataSelector {
-								var metadataSelectorPropertyInterface = propertyValue.(map[string]interface{})
-
-								currentMetadataSelectorValue = fmt.Sprintf("%v", metadataSelectorPropertyInterface["value"])
-								currentMetadataSelectorUnitCode = fmt.Sprintf("%v", metadataSelectorPropertyInterface["unitCode"])
-							}
-						}
-						allMultiAttributeValues = buildString(allMultiAttributeValues, currentValue, currentUnitCode, currentMetadataSelectorValue, currentMetadataSelectorUnitCode, VALUES_SEPARATOR)
-					}
-					entitiesId = append(entitiesId, entityId)
-					attributes = append(attributes, mapMetric)
-					firstAttributeValue := strings.Split(allMultiAttributeValues, " ")
-					metrics = append(metrics, firstAttributeValue[0])
-					multiAttributeValues = append(multiAttributeValues, allMultiAttributeValues)
-				}
-
-			case interface{}:
-				//We find the attribute
-				if k == mapMetric {
-					foundAttribute = true
-					var currentValue string
-					var currentUnitCode string
-
-					var propertyInterface = attribute.(map[string]interface{})
-					//Getting the property value and unitCode
-					for propertyKey, propertyValue := range propertyInterface {
-						//We get the value if it's a Property or object if it's a Relationship
-						if propertyKey == "value" || propertyKey == "object" {
-							currentValue = fmt.Sprintf("%v", propertyValue)
-						}
-						if propertyKey == "unitCode" {
-							currentUnitCode = fmt.Sprintf("%v", propertyValue)
-						}
-						//Getting metadataSelector value and unitCode
-						if propertyKey == metadataSelector {
-							foundMetadataSelector = true
-							var metadataSelectorPropertyInterface = propertyValue.(map[string]interface{})
-
-							var metadataSelectorValueString = fmt.Sprintf("%v", metadataSelectorPropertyInterface["value"])
-							var metadataSelectorUnitCodeString = fmt.Sprintf("%v", metadataSelectorPropertyInterface["unitCode"])
-
-							mutltiAttributeValue := buildString("", currentValue, currentUnitCode, metadataSelectorValueString, metadataSelectorUnitCodeString, VALUES_SEPARATOR)
-							multiAttributeValues = append(multiAttributeValues, mutltiAttributeValue)
-						}
-					}
-					//If one attribute don't have the metadataSelector
-					if metadataSelector != "" && !foundMetadataSelector {
-						mutltiAttributeValue := buildString("", currentValue, currentUnitCode, "", "", VALUES_SEPARATOR)
-						multiAttributeValues = append(multiAttributeValues, mutltiAttributeValue)
-					}
-
-					entitiesId = append(entitiesId, entityId)
-					attributes = append(attributes, mapMetric)
-					metrics = append(metrics, currentValue)
-				}
-
-				//If key is "location"
-				if k == "location" {
-					var attributeValueInterface = attribute.(map[string]interface{})["value"]
-					hasLocation = true
-					// convert map to json
-					jsonString, _ := json.Marshal(attributeValueInterface)
-					// convert json to struct
-					location := Location{}
-					json.Unmarshal(jsonString, &location)
-
-					latitudes = append(latitudes, location.Coordinates[1])
-					longitudes = append(longitudes, location.Coordinates[0])
-				}
-			default:
-				log.DefaultLogger.Info(k, "is of a type I don't know how to handle")
-			}
+	for _, entity := range entities {
+		// We can't display an entity without location, lines and polygons are displayed at their center
+		geometry, err := entityGeometry(entity, geoproperty)
+		if err != nil {
+			log.DefaultLogger.Warn("invalid entity geometry", "id", entity["id"], "geoproperty", geoproperty, "err", err)
+			continue
 		}
-		// If we don't have location for an entity, but we already find the desired attribute
-		// We can't display an entity without location, so display nothing for this entity
-		if foundAttribute && !hasLocation {
-			entitiesId = entitiesId[:len(entitiesId)-1]
-			attributes = attributes[:len(attributes)-1]
-			metrics = metrics[:len(metrics)-1]
-			if hasMetadataSelector && foundMetadataSelector {
-				multiAttributeValues = multiAttributeValues[:len(multiAttributeValues)-1]
-			}
+		if geometry == nil {
+			continue
 		}
-		//If we have location for an entity but not the desired attribute
-		if hasLocation && !foundAttribute {
-			if mapMetric != "" {
-				latitudes = latitudes[:len(latitudes)-1]
-				longitudes = longitudes[:len(longitudes)-1]
+		latitude, longitude := geometry.center()
+
+		entityId, _ := entity["id"].(string)
+		if mapMetric == "" {
+			//That means user didn't enter MapMetric, but entity has a location. So just display the location
+			entitiesId = append(entitiesId, entityId)
+			attributes = append(attributes, "no metric")
+			metrics.append(float64(0), "")
+			multiAttributeValues.append(nil, "")
+		} else {
+			instance := firstInstance(entity, mapMetric)
+			if instance == nil {
+				continue
+			}
+			entitiesId = append(entitiesId, entityId)
+			attributes = append(attributes, mapMetric)
+			metrics.append(firstInstanceValue(entity, mapMetric))
+			if subProperty, ok := instance[metadataSelector].(map[string]interface{}); ok {
+				multiAttributeValues.append(attributeValue(subProperty), unitCode(subProperty))
 			} else {
-				//That means user didn't enter MapMetric, but entity has a location. So just display the location
-				entitiesId = append(entitiesId, entityId)
-				attributes = append(attributes, "no metric")
-				metrics = append(metrics, "0")
+				multiAttributeValues.append(nil, "")
 			}
 		}
+		latitudes = append(latitudes, latitude)
+		longitudes = append(longitudes, longitude)
 	}
 
 	frame.Fields = append(frame.Fields,
@@ -423,9 +446,7 @@ func transformToWorldMap(qm queryModel, entitiesByte []byte, response backend.Da
 	frame.Fields = append(frame.Fields,
 		data.NewField("attribute", nil, attributes),
 	)
-	frame.Fields = append(frame.Fields,
-		data.NewField("metric", nil, metrics),
-	)
+	frame.Fields = append(frame.Fields, metrics.fields()...)
 	frame.Fields = append(frame.Fields,
 		data.NewField("latitude", nil, latitudes),
 	)
@@ -433,10 +454,7 @@ func transformToWorldMap(qm queryModel, entitiesByte []byte, response backend.Da
 		data.NewField("longitude", nil, longitudes),
 	)
 	if hasMetadataSelector {
-		multiAttributeColumnName := mapMetric + " (" + metadataSelector + ")"
-		frame.Fields = append(frame.Fields,
-			data.NewField(multiAttributeColumnName, nil, multiAttributeValues),
-		)
+		frame.Fields = append(frame.Fields, multiAttributeValues.fields()...)
 	}
 
 	// add the frames to the response
@@ -444,44 +462,18 @@ func transformToWorldMap(qm queryModel, entitiesByte []byte, response backend.Da
 	return response
 }
 
-//Build string with or without multiAttribute and unitCode
-//Ex : 10 CEL (20 MTR) / 10 (20 MTR) / 10 CEL (20) / 10 CEL
-func buildString(accumulator string, value string, valueUnitCode string, metadataSelectorValue string, metadataSelectorUnitCode string, VALUES_SEPARATOR string) (buildedString string) {
-	var result string
-	var metadataSelectorBuildString string
-	var valueUnitCodeBuildString = valueUnitCode
-	var metadataSelectorUnitCodeBuildString = metadataSelectorUnitCode
-
-	if valueUnitCodeBuildString != "" {
-		valueUnitCodeBuildString = " " + valueUnitCodeBuildString
-	}
-	if metadataSelectorUnitCodeBuildString != "" {
-		metadataSelectorUnitCodeBuildString = " " + metadataSelectorUnitCodeBuildString
-	}
-	if metadataSelectorValue != "" {
-		metadataSelectorBuildString = " (" + metadataSelectorValue + metadataSelectorUnitCodeBuildString + ")"
-	}
-
-	if accumulator != "" {
-		result = accumulator + VALUES_SEPARATOR + " " + value + valueUnitCodeBuildString + metadataSelectorBuildString
-	} else {
-		result = value + valueUnitCodeBuildString + metadataSelectorBuildString
-	}
-	return result
-}
-
 // CheckHealth handles health checks sent from Grafana to the plugin.
 // The main use case for these health checks is the test button on the
 // datasource configuration page which allows users to verify that
 // a datasource is working as expected.
 func (td *SampleDatasource) CheckHealth(ctx context.Context, req *backend.CheckHealthRequest) (*backend.CheckHealthResult, error) {
-	var status = backend.HealthStatusOk
-	var message = "Data source is working !"
+	instance, err := td.im.Get(req.PluginContext)
+	if err != nil {
+		return nil, err
+	}
+	instSetting, _ := instance.(*instanceSettings)
 
-	return &backend.CheckHealthResult{
-		Status:  status,
-		Message: message,
-	}, nil
+	return checkHealth(ctx, instSetting), nil
 }
 
 func newDataSourceInstance(setting backend.DataSourceInstanceSettings) (instancemgmt.Instance, error) {
@@ -492,31 +484,73 @@ func newDataSourceInstance(setting backend.DataSourceInstanceSettings) (instance
 		log.DefaultLogger.Error("error marshalling", "err", err)
 		return nil, err
 	}
-	//get secure settings (client_secret)
+	//get secure settings (client_secret, TLS certificates...)
 	var secureData = setting.DecryptedSecureJSONData
-	clientSecret := secureData["clientSecret"]
 
-	return &instanceSettings{
+	//default pagination settings
+	if settings.PageSize <= 0 {
+		settings.PageSize = defaultPageSize
+	}
+	if settings.MaxEntities <= 0 {
+		settings.MaxEntities = defaultMaxEntities
+	}
+	if settings.MaxConcurrentQueries <= 0 {
+		settings.MaxConcurrentQueries = defaultMaxConcurrentQueries
+	}
+	if settings.Timeout <= 0 {
+		settings.Timeout = defaultTimeout
+	}
+	if settings.PollInterval <= 0 {
+		settings.PollInterval = defaultPollInterval
+	}
+
+	listenAddress, err := notificationListenAddress(settings)
+	if err != nil {
+		log.DefaultLogger.Error("invalid notification settings", "err", err)
+		return nil, err
+	}
+
+	timeout := time.Duration(settings.Timeout) * time.Second
+	tlsConfig, err := newTLSConfig(settings, secureData)
+	if err != nil {
+		log.DefaultLogger.Error("invalid TLS settings", "err", err)
+		return nil, err
+	}
+
+	instSetting := &instanceSettings{
 		authServerUrl:    settings.AuthServerUrl,
 		resource:         settings.Resource,
-		clientId:         settings.ClientId,
-		clientSecret:     clientSecret,
 		contextBrokerUrl: settings.ContextBrokerUrl,
-	}, nil
+		tenant:           settings.Tenant,
+		apiVersion:       settings.ApiVersion,
+		pageSize:         settings.PageSize,
+		maxEntities:      settings.MaxEntities,
+		querySlots:       make(chan struct{}, settings.MaxConcurrentQueries),
+		timeout:          timeout,
+		httpClient:       newHTTPClient(settings, tlsConfig, timeout),
+		authHttpClient:   newHTTPClient(settings, newAuthServerTLSConfig(settings), timeout),
+		uid:              setting.UID,
+		notificationUrl:  settings.NotificationUrl,
+		listenAddress:    listenAddress,
+		pollInterval:     time.Duration(settings.PollInterval) * time.Second,
+		context:          settings.Context,
+	}
+	instSetting.disposed, instSetting.dispose = context.WithCancel(context.Background())
+
+	instSetting.auth, err = newAuthenticator(settings, setting, instSetting)
+	if err != nil {
+		log.DefaultLogger.Error("invalid authentication settings", "err", err)
+		return nil, err
+	}
+
+	return instSetting, nil
 }
 
 func (s *instanceSettings) Dispose() {
 	// Called before creatinga a new instance to allow plugin authors
 	// to cleanup.
-}
-
-func dateFormat(inputDate string) string {
-	if inputDate != "" {
-		//input format is like this layout
-		layout := "2006-01-02T15:04:05.999999Z"
-		time, _ := time.Parse(layout, inputDate)
-		timeToDisplay := time.Format("2 Jan 2006 15:04:05")
-		return timeToDisplay
-	}
-	return inputDate
+	//The live streams still running with the previous settings stop, Grafana runs them again with the new instance
+	s.dispose()
+	s.httpClient.CloseIdleConnections()
+	s.authHttpClient.CloseIdleConnections()
 }
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+)
+
+// Recursive descent parser of the expressions combining operands with operators and parentheses,
+// like the scope queries and the type queries of NGSI-LD
+type expressionParser struct {
+	query string
+	pos   int
+	// Characters combining two terms
+	operators string
+	// Parse the operand starting at the current position
+	parseOperand func(p *expressionParser) error
+}
+
+// Check that the whole query is a valid expression, kind names the query in the error messages
+func validateExpression(kind string, query string, operators string, parseOperand func(p *expressionParser) error) error {
+	p := &expressionParser{query: query, operators: operators, parseOperand: parseOperand}
+	if err := p.parseExpression(); err != nil {
+		return fmt.Errorf("invalid %s %q: %w", kind, query, err)
+	}
+	if p.skipSpaces(); p.pos < len(p.query) {
+		return fmt.Errorf("invalid %s %q: unexpected %q at position %d", kind, query, p.query[p.pos], p.pos+1)
+	}
+	return nil
+}
+
+func (p *expressionParser) skipSpaces() {
+	for p.pos < len(p.query) && p.query[p.pos] == ' ' {
+		p.pos++
+	}
+}
+
+// Tell if the current character ends an operand
+func (p *expressionParser) atOperandEnd() bool {
+	return p.pos >= len(p.query) || p.query[p.pos] == ' ' || p.query[p.pos] == ')' || strings.IndexByte(p.operators, p.query[p.pos]) >= 0
+}
+
+// expression = term { operator term }
+func (p *expressionParser) parseExpression() error {
+	for {
+		if err := p.parseTerm(); err != nil {
+			return err
+		}
+		p.skipSpaces()
+		if p.pos >= len(p.query) || strings.IndexByte(p.operators, p.query[p.pos]) < 0 {
+			return nil
+		}
+		p.pos++
+	}
+}
+
+// term = "(" expression ")" | operand
+func (p *expressionParser) parseTerm() error {
+	p.skipSpaces()
+	if p.pos >= len(p.query) {
+		return errors.New("an operand is expected at the end of the query")
+	}
+	if p.query[p.pos] != '(' {
+		return p.parseOperand(p)
+	}
+	p.pos++
+	if err := p.parseExpression(); err != nil {
+		return err
+	}
+	p.skipSpaces()
+	if p.pos >= len(p.query) || p.query[p.pos] != ')' {
+		return errors.New("a closing parenthesis is missing")
+	}
+	p.pos++
+	return nil
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"errors"
+	"testing"
+)
+
+// Operand made of letters only, to test the expressions apart from the scope and type queries
+func parseTestOperand(p *expressionParser) error {
+	start := p.pos
+	for p.pos < len(p.query) && p.query[p.pos] >= 'a' && p.query[p.pos] <= 'z' {
+		p.pos++
+	}
+	if p.pos == start {
+		return errors.New("an operand is expected")
+	}
+	return nil
+}
+
+func TestValidateExpression(t *testing.T) {
+	tests := []struct {
+		query   string
+		wantErr bool
+	}{
+		{query: "a"},
+		{query: "a;b"},
+		{query: "a | b"},
+		{query: " a ; b | c "},
+		{query: "(a)"},
+		{query: "(a;b)|c"},
+		{query: "((a|b);(c|d))"},
+		{query: "", wantErr: true},
+		{query: "a;", wantErr: true},
+		{query: ";a", wantErr: true},
+		{query: "a;;b", wantErr: true},
+		{query: "a b", wantErr: true},
+		{query: "a,b", wantErr: true},
+		{query: "()", wantErr: true},
+		{query: "(a", wantErr: true},
+		{query: "a)", wantErr: true},
+		{query: "(a)(b)", wantErr: true},
+		{query: "a(b)", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			if err := validateExpression("test query", tt.query, ";|", parseTestOperand); (err != nil) != tt.wantErr {
+				t.Errorf("validateExpression(%q) error = %v, wantErr %v", tt.query, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestValidateTypeQuery(t *testing.T) {
+	tests := []struct {
+		typeQuery string
+		wantErr   bool
+	}{
+		{typeQuery: ""},
+		{typeQuery: "Building"},
+		{typeQuery: "Building,Room"},
+		{typeQuery: "Building, Room"},
+		{typeQuery: "(Sensor;Device)|Vehicle"},
+		{typeQuery: "https://uri.etsi.org/ngsi-ld/default-context/Sensor"},
+		{typeQuery: "ngsi-ld:Sensor|Device"},
+		{typeQuery: "Building,", wantErr: true},
+		{typeQuery: "Sensor;;Device", wantErr: true},
+		{typeQuery: "(Sensor;Device", wantErr: true},
+		{typeQuery: "Sensor)", wantErr: true},
+		{typeQuery: "Sensor(Device)", wantErr: true},
+		{typeQuery: "Sensor Device", wantErr: true},
+		{typeQuery: "()", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.typeQuery, func(t *testing.T) {
+			if err := validateTypeQuery(tt.typeQuery); (err != nil) != tt.wantErr {
+				t.Errorf("validateTypeQuery(%q) error = %v, wantErr %v", tt.typeQuery, err, tt.wantErr)
+			}
+		})
+	}
+}
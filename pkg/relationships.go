@@ -0,0 +1,206 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	// Number of levels of relationships followed when the query does not tell it
+	defaultJoinLevel = 1
+	// Maximum number of levels of relationships followed by a query
+	maxJoinLevel = 5
+	// Number of linked entities asked to the broker in a single request, their ids are sent in the url
+	linkedEntitiesBatchSize = 50
+)
+
+// Check the relationships to follow of the query model, and return the number of levels to follow
+func validateJoinLevel(qm queryModel) (int, error) {
+	if qm.Relationships == "" || qm.JoinLevel == 0 {
+		return defaultJoinLevel, nil
+	}
+	if qm.JoinLevel < 0 || qm.JoinLevel > maxJoinLevel {
+		return 0, fmt.Errorf("invalid join level %d, relationships can be followed from 1 to %d levels", qm.JoinLevel, maxJoinLevel)
+	}
+	return qm.JoinLevel, nil
+}
+
+// Ask the broker to put the linked entities in the relationships of the entities, since NGSI-LD 1.8.
+// The query model must have been checked by validateJoinLevel.
+func setJoinParams(q url.Values, qm queryModel, instSetting *instanceSettings) {
+	if qm.Relationships == "" || !supportsNgsiLdVersion(instSetting, 1, 8) {
+		return
+	}
+	q.Set("join", "inline")
+	q.Set("joinLevel", strconv.Itoa(qm.JoinLevel))
+}
+
+// Follow the relationships of the query and add the attributes of the linked entities to the entities,
+// prefixed by the relationships leading to them : refRoom.name, refRoom.refBuilding.name...
+// The linked entities sent by the broker are used, the others are fetched by their ids.
+func addLinkedEntities(ctx context.Context, qm queryModel, entitiesByte []byte, instSetting *instanceSettings) ([]byte, error) {
+	relationships := splitList(qm.Relationships)
+
+	var entities []map[string]interface{}
+	if err := json.Unmarshal(entitiesByte, &entities); err != nil {
+		return nil, err
+	}
+
+	//Fetch the linked entities level by level, each entity is fetched once
+	var fetched = map[string]map[string]interface{}{}
+	var requested = map[string]bool{}
+	frontier := entities
+	for level := 0; level < qm.JoinLevel && len(frontier) > 0; level++ {
+		var next []map[string]interface{}
+		var missing []string
+		for _, entity := range frontier {
+			for _, relationship := range relationships {
+				for _, instance := range attributeInstances(entity[relationship]) {
+					if embedded := embeddedEntities(instance); len(embedded) > 0 {
+						next = append(next, embedded...)
+						continue
+					}
+					for _, id := range relationshipObjects(instance) {
+						if linked, found := fetched[id]; found {
+							next = append(next, linked)
+						} else if !requested[id] {
+							requested[id] = true
+							missing = append(missing, id)
+						}
+					}
+				}
+			}
+		}
+
+		linked, err := getEntitiesByIds(ctx, qm, missing, instSetting)
+		if err != nil {
+			return nil, err
+		}
+		for _, entity := range linked {
+			if id, ok := entity["id"].(string); ok {
+				fetched[id] = entity
+			}
+		}
+		frontier = append(next, linked...)
+	}
+
+	for _, entity := range entities {
+		for name, attribute := range linkedAttributes(entity, relationships, fetched, qm.JoinLevel) {
+			if _, found := entity[name]; !found {
+				entity[name] = attribute
+			}
+		}
+	}
+	//The linked entities sent by the broker are now attributes of the entities
+	for _, entity := range entities {
+		for _, name := range attributeNames(entity) {
+			for _, instance := range attributeInstances(entity[name]) {
+				delete(instance, "entity")
+			}
+		}
+	}
+	return json.Marshal(entities)
+}
+
+// Return the attributes of the entities linked to an entity, up to the given level of relationships.
+// When a relationship targets several entities, the attributes of the first ones are kept.
+func linkedAttributes(entity map[string]interface{}, relationships []string, fetched map[string]map[string]interface{}, level int) map[string]interface{} {
+	var attributes = map[string]interface{}{}
+	if level == 0 {
+		return attributes
+	}
+	for _, relationship := range relationships {
+		for _, instance := range attributeInstances(entity[relationship]) {
+			linkedEntities := embeddedEntities(instance)
+			if len(linkedEntities) == 0 {
+				for _, id := range relationshipObjects(instance) {
+					if linked, found := fetched[id]; found {
+						linkedEntities = append(linkedEntities, linked)
+					}
+				}
+			}
+			for _, linked := range linkedEntities {
+				for _, name := range attributeNames(linked) {
+					if _, found := attributes[relationship+"."+name]; !found {
+						attributes[relationship+"."+name] = linked[name]
+					}
+				}
+				for name, attribute := range linkedAttributes(linked, relationships, fetched, level-1) {
+					if _, found := attributes[relationship+"."+name]; !found {
+						attributes[relationship+"."+name] = attribute
+					}
+				}
+			}
+		}
+	}
+	return attributes
+}
+
+// Return the ids of the entities targeted by a relationship, a relationship can target several entities
+func relationshipObjects(instance map[string]interface{}) []string {
+	var ids []string
+	switch object := instance["object"].(type) {
+	case string:
+		ids = append(ids, object)
+	case []interface{}:
+		for _, element := range object {
+			if id, ok := element.(string); ok {
+				ids = append(ids, id)
+			}
+		}
+	}
+	if objectList, ok := instance["objectList"].([]interface{}); ok {
+		for _, element := range objectList {
+			if target, ok := element.(map[string]interface{}); ok {
+				if id, ok := target["object"].(string); ok {
+					ids = append(ids, id)
+				}
+			}
+		}
+	}
+	return ids
+}
+
+// Return the linked entities put in a relationship by the broker
+func embeddedEntities(instance map[string]interface{}) []map[string]interface{} {
+	var entities []map[string]interface{}
+	switch embedded := instance["entity"].(type) {
+	case map[string]interface{}:
+		entities = append(entities, embedded)
+	case []interface{}:
+		for _, element := range embedded {
+			if entity, ok := element.(map[string]interface{}); ok {
+				entities = append(entities, entity)
+			}
+		}
+	}
+	return entities
+}
+
+// Get the linked entities by their ids, whatever their type and the filters of the query
+func getEntitiesByIds(ctx context.Context, qm queryModel, ids []string, instSetting *instanceSettings) ([]map[string]interface{}, error) {
+	var entities []map[string]interface{}
+	lookup := queryModel{Context: qm.Context, Tenant: qm.Tenant}
+	for start := 0; start < len(ids); start += linkedEntitiesBatchSize {
+		end := start + linkedEntitiesBatchSize
+		if end > len(ids) {
+			end = len(ids)
+		}
+		lookup.EntityId = strings.Join(ids[start:end], ",")
+
+		body, _, err := getEntitesByType(ctx, lookup, instSetting)
+		if err != nil {
+			return nil, fmt.Errorf("unable to get the linked entities: %w", err)
+		}
+		var batch []map[string]interface{}
+		if err := json.Unmarshal(body, &batch); err != nil {
+			return nil, fmt.Errorf("invalid linked entities response from the context broker: %w", err)
+		}
+		entities = append(entities, batch...)
+	}
+	return entities, nil
+}
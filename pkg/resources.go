@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/resource/httpadapter"
+)
+
+// Entity listed by the /entities resource
+type entitySummary struct {
+	Id   string `json:"id"`
+	Type string `json:"type"`
+}
+
+// Page of entities answered by the /entities resource
+type entitiesPage struct {
+	Entities     []entitySummary `json:"entities"`
+	ResultsCount int             `json:"resultsCount,omitempty"`
+	// Offset of the next page, absent on the last page
+	NextOffset int `json:"nextOffset,omitempty"`
+}
+
+// Build the handler of the resources called by the query editor to list what exists on the broker :
+// /types, /attributes?type= and /entities?type=&idPrefix=&offset=&limit=
+// Each resource accepts the context and tenant parameters, the defaults of the datasource are used without them.
+func newResourceHandler(td *SampleDatasource) backend.CallResourceHandler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/types", td.resourceHandler(listTypes))
+	mux.HandleFunc("/attributes", td.resourceHandler(listAttributes))
+	mux.HandleFunc("/entities", td.resourceHandler(listEntities))
+	return httpadapter.New(mux)
+}
+
+// A resource answers a value sent as JSON to the query editor
+type resourceFunc func(ctx context.Context, params url.Values, instSetting *instanceSettings) (interface{}, error)
+
+// Error on the parameters of a resource call
+type resourceParamError struct {
+	message string
+}
+
+func (e *resourceParamError) Error() string {
+	return e.message
+}
+
+// Wrap a resource into an HTTP handler getting the datasource instance of the call and sending the result as JSON
+func (td *SampleDatasource) resourceHandler(resource resourceFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			writeResourceError(w, http.StatusMethodNotAllowed, errors.New("only GET is allowed"))
+			return
+		}
+		instance, err := td.im.Get(httpadapter.PluginConfigFromContext(r.Context()))
+		if err != nil {
+			writeResourceError(w, http.StatusInternalServerError, err)
+			return
+		}
+		instSetting, _ := instance.(*instanceSettings)
+
+		result, err := resource(r.Context(), r.URL.Query(), instSetting)
+		if err != nil {
+			var paramErr *resourceParamError
+			if errors.As(err, &paramErr) {
+				writeResourceError(w, http.StatusBadRequest, err)
+			} else {
+				log.DefaultLogger.Warn("resource call failed", "path", r.URL.Path, "err", err)
+				writeResourceError(w, http.StatusBadGateway, err)
+			}
+			return
+		}
+
+		body, err := json.Marshal(result)
+		if err != nil {
+			writeResourceError(w, http.StatusInternalServerError, err)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(body)
+	}
+}
+
+func writeResourceError(w http.ResponseWriter, status int, err error) {
+	body, _ := json.Marshal(map[string]string{"message": err.Error()})
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(body)
+}
+
+// List the entity types known by the broker, in alphabetical order
+func listTypes(ctx context.Context, params url.Values, instSetting *instanceSettings) (interface{}, error) {
+	body, _, err := getBrokerResource(ctx, "/ngsi-ld/v1/types", url.Values{}, params, instSetting)
+	if err != nil {
+		return nil, err
+	}
+	//An EntityTypeList, or a list of EntityType with details
+	var typeList struct {
+		TypeList []string `json:"typeList"`
+	}
+	if json.Unmarshal(body, &typeList) == nil && typeList.TypeList != nil {
+		return sortedNames(typeList.TypeList), nil
+	}
+	var types []struct {
+		TypeName string `json:"typeName"`
+	}
+	if err := json.Unmarshal(body, &types); err != nil {
+		return nil, fmt.Errorf("invalid entity types response from the context broker: %w", err)
+	}
+	var names []string
+	for _, entityType := range types {
+		names = append(names, entityType.TypeName)
+	}
+	return sortedNames(names), nil
+}
+
+// List the attributes known by the broker, or the attributes of the entities of a type, in alphabetical order
+func listAttributes(ctx context.Context, params url.Values, instSetting *instanceSettings) (interface{}, error) {
+	if entityType := params.Get("type"); entityType != "" {
+		body, _, err := getBrokerResource(ctx, "/ngsi-ld/v1/types/"+url.PathEscape(entityType), url.Values{}, params, instSetting)
+		var brokerErr *brokerError
+		if errors.As(err, &brokerErr) && brokerErr.statusCode == http.StatusNotFound {
+			//No entity of this type yet
+			return []string{}, nil
+		}
+		if err != nil {
+			return nil, err
+		}
+		var typeInfo struct {
+			AttributeDetails []struct {
+				AttributeName string `json:"attributeName"`
+			} `json:"attributeDetails"`
+		}
+		if err := json.Unmarshal(body, &typeInfo); err != nil {
+			return nil, fmt.Errorf("invalid entity type response from the context broker: %w", err)
+		}
+		var names []string
+		for _, attribute := range typeInfo.AttributeDetails {
+			names = append(names, attribute.AttributeName)
+		}
+		return sortedNames(names), nil
+	}
+
+	body, _, err := getBrokerResource(ctx, "/ngsi-ld/v1/attributes", url.Values{}, params, instSetting)
+	if err != nil {
+		return nil, err
+	}
+	//An AttributeList, or a list of Attribute with details
+	var attributeList struct {
+		AttributeList []string `json:"attributeList"`
+	}
+	if json.Unmarshal(body, &attributeList) == nil && attributeList.AttributeList != nil {
+		return sortedNames(attributeList.AttributeList), nil
+	}
+	var attributes []struct {
+		AttributeName string `json:"attributeName"`
+	}
+	if err := json.Unmarshal(body, &attributes); err != nil {
+		return nil, fmt.Errorf("invalid attributes response from the context broker: %w", err)
+	}
+	var names []string
+	for _, attribute := range attributes {
+		names = append(names, attribute.AttributeName)
+	}
+	return sortedNames(names), nil
+}
+
+// List a page of the ids of the entities, optionally of a type and with ids starting with a prefix.
+// The size of the pages can't exceed the page size of the datasource.
+func listEntities(ctx context.Context, params url.Values, instSetting *instanceSettings) (interface{}, error) {
+	limit := instSetting.pageSize
+	if params.Get("limit") != "" {
+		value, err := strconv.Atoi(params.Get("limit"))
+		if err != nil || value <= 0 {
+			return nil, &resourceParamError{message: "invalid limit " + strconv.Quote(params.Get("limit"))}
+		}
+		if value < limit {
+			limit = value
+		}
+	}
+	offset := 0
+	if params.Get("offset") != "" {
+		value, err := strconv.Atoi(params.Get("offset"))
+		if err != nil || value < 0 {
+			return nil, &resourceParamError{message: "invalid offset " + strconv.Quote(params.Get("offset"))}
+		}
+		offset = value
+	}
+	if err := validateTypeQuery(params.Get("type")); err != nil {
+		return nil, &resourceParamError{message: err.Error()}
+	}
+
+	q := url.Values{}
+	if params.Get("type") != "" {
+		q.Set("type", params.Get("type"))
+	}
+	if params.Get("idPrefix") != "" {
+		q.Set("idPattern", "^"+regexp.QuoteMeta(params.Get("idPrefix"))+".*")
+	}
+	q.Set("limit", strconv.Itoa(limit))
+	q.Set("offset", strconv.Itoa(offset))
+	q.Set("count", "true")
+	q.Set("options", "keyValues")
+	//Only the ids and types are needed, the brokers implementing NGSI-LD 1.8 can leave out the attributes
+	if supportsNgsiLdVersion(instSetting, 1, 8) {
+		q.Set("pick", "id,type")
+	}
+
+	body, header, err := getBrokerResource(ctx, "/ngsi-ld/v1/entities", q, params, instSetting)
+	if err != nil {
+		return nil, err
+	}
+	var entities []map[string]interface{}
+	if err := json.Unmarshal(body, &entities); err != nil {
+		return nil, fmt.Errorf("invalid entities response from the context broker: %w", err)
+	}
+
+	page := entitiesPage{Entities: []entitySummary{}}
+	for _, entity := range entities {
+		id, _ := entity["id"].(string)
+		page.Entities = append(page.Entities, entitySummary{Id: id, Type: listString(entity["type"])})
+	}
+	page.ResultsCount, _ = strconv.Atoi(header.Get("NGSILD-Results-Count"))
+	if len(entities) == limit && (page.ResultsCount == 0 || offset+limit < page.ResultsCount) {
+		page.NextOffset = offset + limit
+	}
+	return page, nil
+}
+
+// Get a resource of the broker, with the context and the tenant given in the parameters of the resource call,
+// or the default ones of the datasource
+func getBrokerResource(ctx context.Context, resource string, q url.Values, params url.Values, instSetting *instanceSettings) ([]byte, http.Header, error) {
+	u, err := url.ParseRequestURI(instSetting.contextBrokerUrl + resource)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid context broker url: %w", err)
+	}
+	u.RawQuery = q.Encode()
+
+	tenant := params.Get("tenant")
+	if tenant == "" {
+		tenant = instSetting.tenant
+	}
+	ldContext := params.Get("context")
+	if ldContext == "" {
+		ldContext = instSetting.context
+	}
+	r, err := newBrokerRequest(ctx, u.String(), ldContext, tenant)
+	if err != nil {
+		return nil, nil, err
+	}
+	r.Header.Set("Accept", "application/json")
+
+	resp, err := doAuthenticatedRequest(r, instSetting)
+	if err != nil {
+		return nil, nil, err
+	}
+	body, err := readBrokerResponse(resp)
+	return body, resp.Header, err
+}
+
+// Sort the names and remove the empty ones, an empty list is sent as an empty JSON array
+func sortedNames(names []string) []string {
+	var sorted = []string{}
+	for _, name := range names {
+		if name != "" {
+			sorted = append(sorted, name)
+		}
+	}
+	sort.Strings(sorted)
+	return sorted
+}
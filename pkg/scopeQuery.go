@@ -0,0 +1,62 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// Characters with a meaning in a scope query, they can't be used in a scope level
+const scopeQuerySeparators = "/#+,;|()"
+
+// Check the scope query of the query model before it is sent to the broker.
+// A scope query combines scopes with ; (and), | or , (or), and parentheses :
+// /France/Grenoble/# ; (/+/Building3 | /Building4) or /Building3,/Building4
+func validateScopeQuery(scopeQuery string) error {
+	if strings.TrimSpace(scopeQuery) == "" {
+		return nil
+	}
+	return validateExpression("scope query", scopeQuery, ",;|", parseScope)
+}
+
+// Add the scope query to the query parameters sent to the broker
+func setScopeQueryParams(q url.Values, qm queryModel) {
+	if scopeQuery := strings.TrimSpace(qm.ScopeQuery); scopeQuery != "" {
+		q.Set("scopeQ", scopeQuery)
+	}
+}
+
+// scope = "/#" | "/" level { "/" level } [ "/#" ], a level being a name or the + wildcard
+func parseScope(p *expressionParser) error {
+	if p.query[p.pos] != '/' {
+		return fmt.Errorf("a scope must start with / at position %d", p.pos+1)
+	}
+	for p.pos < len(p.query) && p.query[p.pos] == '/' {
+		p.pos++
+		start := p.pos
+		for p.pos < len(p.query) && p.query[p.pos] != ' ' && !strings.ContainsRune(scopeQuerySeparators, rune(p.query[p.pos])) {
+			p.pos++
+		}
+		if p.pos > start {
+			continue
+		}
+		if p.pos >= len(p.query) {
+			return errors.New("a scope can't end with /")
+		}
+		switch p.query[p.pos] {
+		case '+':
+			p.pos++
+		case '#':
+			//all the descendants, nothing can follow
+			p.pos++
+			return nil
+		default:
+			return fmt.Errorf("an empty scope level is not allowed at position %d", p.pos+1)
+		}
+		if p.pos < len(p.query) && p.query[p.pos] != '/' && !p.atOperandEnd() {
+			return fmt.Errorf("the + wildcard must be a whole scope level at position %d", p.pos)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import "testing"
+
+func TestValidateScopeQuery(t *testing.T) {
+	tests := []struct {
+		scopeQuery string
+		wantErr    bool
+	}{
+		{scopeQuery: ""},
+		{scopeQuery: "  "},
+		{scopeQuery: "/France"},
+		{scopeQuery: "/France/Grenoble"},
+		{scopeQuery: "/#"},
+		{scopeQuery: "/France/#"},
+		{scopeQuery: "/+/Building3"},
+		{scopeQuery: "/France/+"},
+		{scopeQuery: "/A;/B"},
+		{scopeQuery: "/A|/B"},
+		{scopeQuery: "/A,/B"},
+		{scopeQuery: "/A , /B/#"},
+		{scopeQuery: "/France/Grenoble/# ; (/+/Building3 | /Building4)"},
+		{scopeQuery: "((/A;/B)|/C),/D"},
+		{scopeQuery: "France", wantErr: true},
+		{scopeQuery: "/", wantErr: true},
+		{scopeQuery: "/France/", wantErr: true},
+		{scopeQuery: "/France//Grenoble", wantErr: true},
+		{scopeQuery: "/France/#/Grenoble", wantErr: true},
+		{scopeQuery: "/France/Gre+", wantErr: true},
+		{scopeQuery: "/France/+Grenoble", wantErr: true},
+		{scopeQuery: "/A,", wantErr: true},
+		{scopeQuery: "/A;;/B", wantErr: true},
+		{scopeQuery: ",/A", wantErr: true},
+		{scopeQuery: "(/A|/B", wantErr: true},
+		{scopeQuery: "/A)", wantErr: true},
+		{scopeQuery: "/A /B", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scopeQuery, func(t *testing.T) {
+			if err := validateScopeQuery(tt.scopeQuery); (err != nil) != tt.wantErr {
+				t.Errorf("validateScopeQuery(%q) error = %v, wantErr %v", tt.scopeQuery, err, tt.wantErr)
+			}
+		})
+	}
+}
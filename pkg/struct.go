@@ -1,5 +1,11 @@
 package main
 
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
 type Token struct {
 	Access_token       string `json:"access_token"`
 	Expires_in         int    `json:"expires_in"`
@@ -10,32 +16,105 @@ type Token struct {
 	Scope              string `json:"scope"`
 }
 
-type Location struct {
-	Type        string    `json:"type"`
-	Coordinates []float64 `json:"coordinates"`
+type TokenError struct {
+	Error             string `json:"error"`
+	Error_description string `json:"error_description"`
+}
+
+type ProblemDetails struct {
+	Type   string `json:"type"`
+	Title  string `json:"title"`
+	Detail string `json:"detail"`
 }
 
 type queryModel struct {
-	EntityId         string `json:"entityId"`
-	Format           string `json:"format"`
-	MapMetric        string `json:"attribute"`
-	Context          string `json:"context"`
-	EntityType       string `json:"entityType"`
-	ValueFilterQuery string `json:"valueFilterQuery"`
-	MetadataSelector string `json:"metadataSelector"`
+	EntityId           string `json:"entityId"`
+	IdPattern          string `json:"idPattern"`
+	Format             string `json:"format"`
+	MapMetric          string `json:"attribute"`
+	Context            string `json:"context"`
+	EntityType         string `json:"entityType"`
+	ValueFilterQuery   string `json:"valueFilterQuery"`
+	MetadataSelector   string `json:"metadataSelector"`
+	QueryMode          string `json:"queryMode"`
+	TimeProperty       string `json:"timeProperty"`
+	AggrMethods        string `json:"aggrMethods"`
+	AggrPeriodDuration string `json:"aggrPeriodDuration"`
+	Tenant             string `json:"tenant"`
+	Georel             string `json:"georel"`
+	Geometry           string `json:"geometry"`
+	Coordinates        string `json:"coordinates"`
+	Geoproperty        string `json:"geoproperty"`
+	DisplayGeoproperty string `json:"displayGeoproperty"`
+	Attrs              string `json:"attrs"`
+	Pick               string `json:"pick"`
+	Omit               string `json:"omit"`
+	ScopeQuery         string `json:"scopeQuery"`
+	Relationships      string `json:"relationships"`
+	JoinLevel          int    `json:"joinLevel"`
+	VariableType       string `json:"variableType"`
+	Live               bool   `json:"live"`
 }
 
 type instanceSettings struct {
 	authServerUrl    string
 	resource         string
-	clientId         string
-	clientSecret     string
 	contextBrokerUrl string
+	tenant           string
+	apiVersion       string
+	pageSize         int
+	maxEntities      int
+	auth             authenticator
+	querySlots       chan struct{}
+	timeout          time.Duration
+	httpClient       *http.Client
+	authHttpClient   *http.Client
+	uid              string
+	notificationUrl  string
+	listenAddress    string
+	pollInterval     time.Duration
+	context          string
+	// Done when the instance is disposed, the live streams of the instance stop with it
+	disposed context.Context
+	dispose  context.CancelFunc
 }
 
 type settingsModel struct {
-	AuthServerUrl    string `json:"authServerUrl"`
-	Resource         string `json:"resource"`
-	ClientId         string `json:"clientId"`
-	ContextBrokerUrl string `json:"contextBrokerUrl"`
+	AuthServerUrl        string `json:"authServerUrl"`
+	Resource             string `json:"resource"`
+	ClientId             string `json:"clientId"`
+	ContextBrokerUrl     string `json:"contextBrokerUrl"`
+	PageSize             int    `json:"pageSize"`
+	MaxEntities          int    `json:"maxEntities"`
+	MaxConcurrentQueries int    `json:"maxConcurrentQueries"`
+	Timeout              int    `json:"timeout"`
+	TlsSkipVerify        bool   `json:"tlsSkipVerify"`
+	TlsAuth              bool   `json:"tlsAuth"`
+	TlsAuthWithCACert    bool   `json:"tlsAuthWithCACert"`
+	ServerName           string `json:"serverName"`
+	AuthMode             string `json:"authMode"`
+	Scope                string `json:"scope"`
+	Audience             string `json:"audience"`
+	Username             string `json:"username"`
+	ApiKeyHeader         string `json:"apiKeyHeader"`
+	Tenant               string `json:"tenant"`
+	ApiVersion           string `json:"apiVersion"`
+	NotificationUrl      string `json:"notificationUrl"`
+	ListenAddress        string `json:"listenAddress"`
+	PollInterval         int    `json:"pollInterval"`
+	Context              string `json:"context"`
+}
+
+// Result of a paginated query on entities
+type pagination struct {
+	// Total number of entities matching the query, as sent by the broker
+	resultsCount int
+	// True if the maximum number of entities was reached, or the paging ended, before fetching all of them
+	truncated bool
+	// Number of entities fetched
+	fetched int
+	// True if the broker only sent a part of the temporal values (206 Partial Content)
+	partialValues bool
+	// Range of the temporal values sent by the broker, from the Content-Range header
+	partialRange string
 }
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// NGSI-LD subscription created on the broker for a live query
+type subscription struct {
+	Type              string             `json:"type"`
+	Entities          []entitySelector   `json:"entities,omitempty"`
+	WatchedAttributes []string           `json:"watchedAttributes,omitempty"`
+	Q                 string             `json:"q,omitempty"`
+	GeoQ              *subscriptionGeoQ  `json:"geoQ,omitempty"`
+	ScopeQ            string             `json:"scopeQ,omitempty"`
+	Notification      notificationParams `json:"notification"`
+}
+
+type entitySelector struct {
+	Id        string `json:"id,omitempty"`
+	IdPattern string `json:"idPattern,omitempty"`
+	Type      string `json:"type"`
+}
+
+type subscriptionGeoQ struct {
+	Geometry    string          `json:"geometry"`
+	Coordinates json.RawMessage `json:"coordinates"`
+	Georel      string          `json:"georel"`
+	Geoproperty string          `json:"geoproperty,omitempty"`
+}
+
+type notificationParams struct {
+	Attributes []string             `json:"attributes,omitempty"`
+	Format     string               `json:"format"`
+	SysAttrs   bool                 `json:"sysAttrs"`
+	Endpoint   notificationEndpoint `json:"endpoint"`
+}
+
+type notificationEndpoint struct {
+	Uri    string `json:"uri"`
+	Accept string `json:"accept"`
+}
+
+// Build the subscription notifying the changes of the entities selected by the query model to the endpoint.
+// The entities are selected by type in a subscription, the query model must have been checked by validateLiveQuery.
+func newSubscription(qm queryModel, endpoint string) subscription {
+	s := subscription{
+		Type:   "Subscription",
+		Q:      qm.ValueFilterQuery,
+		ScopeQ: qm.ScopeQuery,
+		Notification: notificationParams{
+			Attributes: splitList(qm.Attrs),
+			Format:     "normalized",
+			SysAttrs:   true,
+			Endpoint:   notificationEndpoint{Uri: endpoint, Accept: "application/json"},
+		},
+	}
+	//Only the changes of the displayed attributes are notified
+	s.WatchedAttributes = s.Notification.Attributes
+
+	//A type expression is understood since NGSI-LD 1.6, the types of a list are selected one by one for the older brokers
+	typeQuery := strings.ReplaceAll(qm.EntityType, " ", "")
+	types := []string{typeQuery}
+	if !strings.ContainsAny(typeQuery, ";|()") {
+		types = splitList(typeQuery)
+	}
+	for _, entityType := range types {
+		switch {
+		case qm.IdPattern != "":
+			s.Entities = append(s.Entities, entitySelector{IdPattern: qm.IdPattern, Type: entityType})
+		case qm.EntityId != "":
+			for _, id := range splitList(qm.EntityId) {
+				s.Entities = append(s.Entities, entitySelector{Id: id, Type: entityType})
+			}
+		default:
+			s.Entities = append(s.Entities, entitySelector{Type: entityType})
+		}
+	}
+
+	if qm.Coordinates != "" {
+		s.GeoQ = &subscriptionGeoQ{
+			Geometry:    qm.Geometry,
+			Coordinates: json.RawMessage(qm.Coordinates),
+			Georel:      qm.Georel,
+			Geoproperty: qm.Geoproperty,
+		}
+	}
+	return s
+}
+
+// Create the subscription of a live query on the broker and return its id
+func createSubscription(ctx context.Context, qm queryModel, endpoint string, instSetting *instanceSettings) (string, error) {
+	u, err := url.ParseRequestURI(instSetting.contextBrokerUrl + "/ngsi-ld/v1/subscriptions")
+	if err != nil {
+		return "", fmt.Errorf("invalid context broker url: %w", err)
+	}
+	body, err := json.Marshal(newSubscription(qm, endpoint))
+	if err != nil {
+		return "", err
+	}
+
+	r, err := newBrokerRequestWithBody(ctx, http.MethodPost, u.String(), body, qm.Context, qm.Tenant)
+	if err != nil {
+		return "", err
+	}
+	resp, err := doAuthenticatedRequest(r, instSetting)
+	if err != nil {
+		return "", err
+	}
+	if _, err := readBrokerResponse(resp); err != nil {
+		return "", fmt.Errorf("unable to create the subscription: %w", err)
+	}
+
+	//The broker gives the subscription it created in the Location header : /ngsi-ld/v1/subscriptions/{id}
+	location := resp.Header.Get("Location")
+	index := strings.LastIndex(location, "/subscriptions/")
+	if index < 0 {
+		return "", errors.New("the context broker did not send the location of the subscription")
+	}
+	id, err := url.PathUnescape(location[index+len("/subscriptions/"):])
+	if err != nil || id == "" {
+		return "", fmt.Errorf("invalid subscription location %q", location)
+	}
+	return id, nil
+}
+
+// Delete a subscription of a live query from the broker
+func deleteSubscription(ctx context.Context, qm queryModel, id string, instSetting *instanceSettings) error {
+	u, err := url.ParseRequestURI(instSetting.contextBrokerUrl + "/ngsi-ld/v1/subscriptions/" + url.PathEscape(id))
+	if err != nil {
+		return fmt.Errorf("invalid context broker url: %w", err)
+	}
+
+	r, err := newBrokerRequestWithBody(ctx, http.MethodDelete, u.String(), nil, qm.Context, qm.Tenant)
+	if err != nil {
+		return err
+	}
+	resp, err := doAuthenticatedRequest(r, instSetting)
+	if err != nil {
+		return err
+	}
+	_, err = readBrokerResponse(resp)
+	return err
+}
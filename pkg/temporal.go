@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+const allowedAggrMethods = "totalCount, distinctCount, sum, avg, min, max, stddev, sumsq"
+
+var aggrMethodsAllowed = map[string]bool{
+	"totalCount":    true,
+	"distinctCount": true,
+	"sum":           true,
+	"avg":           true,
+	"min":           true,
+	"max":           true,
+	"stddev":        true,
+	"sumsq":         true,
+}
+
+// A single point of the temporal evolution of an attribute
+type temporalPoint struct {
+	time  time.Time
+	value *float64
+}
+
+// Return a DataResponse to display data in graph view
+// (The dataResponse contains one frame per entity and attribute with 2 fields : time, value)
+func transformToTimeSeries(qm queryModel, entitiesByte []byte, response backend.DataResponse) backend.DataResponse {
+	var timeProperty = qm.TimeProperty
+	if timeProperty == "" {
+		timeProperty = "observedAt"
+	}
+
+	var entities []map[string]interface{}
+	err := json.Unmarshal(entitiesByte, &entities)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	// Range over entities
+	for _, entity := range entities {
+		entityId, _ := entity["id"].(string)
+
+		// Range over attributes, in a stable order for the frames
+		for _, k := range attributeNames(entity) {
+			v := entity[k]
+			//Points are grouped by datasetId, each datasetId being a different series
+			var datasetIds []string
+			var pointsByDatasetId = map[string][]temporalPoint{}
+			for _, instanceInterface := range attributeInstances(v) {
+				instanceTime, ok := instanceInterface[timeProperty].(string)
+				if !ok {
+					continue
+				}
+				parsedTime, err := time.Parse(time.RFC3339Nano, instanceTime)
+				if err != nil {
+					log.DefaultLogger.Warn("unable to parse temporal instance time", "time", instanceTime, "err", err)
+					continue
+				}
+
+				datasetId, _ := instanceInterface["datasetId"].(string)
+				if _, found := pointsByDatasetId[datasetId]; !found {
+					datasetIds = append(datasetIds, datasetId)
+				}
+				pointsByDatasetId[datasetId] = append(pointsByDatasetId[datasetId], temporalPoint{
+					time:  parsedTime,
+					value: numericValue(instanceInterface["value"]),
+				})
+			}
+
+			for _, datasetId := range datasetIds {
+				points := pointsByDatasetId[datasetId]
+				sort.Slice(points, func(i, j int) bool { return points[i].time.Before(points[j].time) })
+
+				times := make([]time.Time, len(points))
+				values := make([]*float64, len(points))
+				for i, point := range points {
+					times[i] = point.time
+					values[i] = point.value
+				}
+
+				frameName := entityId + " " + k
+				if datasetId != "" {
+					frameName = frameName + " (" + datasetId + ")"
+				}
+				//The series of the alert rules are told apart by their labels
+				var labels data.Labels
+				if qm.Format == "alerting" {
+					labels = seriesLabels(entity, k, datasetId)
+				}
+				frame := data.NewFrame(frameName,
+					data.NewField("time", nil, times),
+					data.NewField(k, labels, values),
+				)
+				response.Frames = append(response.Frames, frame)
+			}
+		}
+	}
+
+	return response
+}
+
+// Return a DataResponse to display aggregated data in graph view
+// (The dataResponse contains one frame per entity and attribute with a time field and one field per aggregation method)
+func transformAggregatedToTimeSeries(qm queryModel, entitiesByte []byte, response backend.DataResponse) backend.DataResponse {
+	var aggrMethods = splitList(qm.AggrMethods)
+
+	var entities []map[string]interface{}
+	err := json.Unmarshal(entitiesByte, &entities)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	// Range over entities
+	for _, entity := range entities {
+		entityId, _ := entity["id"].(string)
+
+		// Range over attributes, in a stable order for the frames
+		for _, k := range attributeNames(entity) {
+			v := entity[k]
+			// Each instance (one per datasetId) holds the aggregated values of every method
+			for _, instanceInterface := range attributeInstances(v) {
+				//Values of the different methods are merged on the start of their period
+				var valuesByTime = map[time.Time][]*float64{}
+				for methodIndex, method := range aggrMethods {
+					buckets, ok := instanceInterface[method].([]interface{})
+					if !ok {
+						continue
+					}
+					for _, bucket := range buckets {
+						// A bucket is sent as [value, startAt, endAt]
+						bucketValues, ok := bucket.([]interface{})
+						if !ok || len(bucketValues) < 2 {
+							continue
+						}
+						startAt, ok := bucketValues[1].(string)
+						if !ok {
+							continue
+						}
+						parsedTime, err := time.Parse(time.RFC3339Nano, startAt)
+						if err != nil {
+							log.DefaultLogger.Warn("unable to parse aggregation period start", "time", startAt, "err", err)
+							continue
+						}
+						if _, found := valuesByTime[parsedTime]; !found {
+							valuesByTime[parsedTime] = make([]*float64, len(aggrMethods))
+						}
+						valuesByTime[parsedTime][methodIndex] = numericValue(bucketValues[0])
+					}
+				}
+				if len(valuesByTime) == 0 {
+					continue
+				}
+
+				times := make([]time.Time, 0, len(valuesByTime))
+				for t := range valuesByTime {
+					times = append(times, t)
+				}
+				sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+
+				datasetId, _ := instanceInterface["datasetId"].(string)
+				frameName := entityId + " " + k
+				if datasetId != "" {
+					frameName = frameName + " (" + datasetId + ")"
+				}
+				frame := data.NewFrame(frameName, data.NewField("time", nil, times))
+				for methodIndex, method := range aggrMethods {
+					values := make([]*float64, len(times))
+					for i, t := range times {
+						values[i] = valuesByTime[t][methodIndex]
+					}
+					//The series of the alert rules are told apart by their labels
+					var labels data.Labels
+					if qm.Format == "alerting" {
+						labels = seriesLabels(entity, k, datasetId)
+						labels["aggrMethod"] = method
+					}
+					frame.Fields = append(frame.Fields, data.NewField(k+" "+method, labels, values))
+				}
+				response.Frames = append(response.Frames, frame)
+			}
+		}
+	}
+
+	return response
+}
+
+// Check that every aggregation method is one defined by NGSI-LD
+func validateAggrMethods(aggrMethods string) error {
+	for _, method := range splitList(aggrMethods) {
+		if !aggrMethodsAllowed[method] {
+			return fmt.Errorf("unknown aggregation method %q, allowed methods are %s", method, allowedAggrMethods)
+		}
+	}
+	return nil
+}
+
+// Return the instances of an attribute, the attribute can be a single instance or a list of instances
+func attributeInstances(attribute interface{}) []map[string]interface{} {
+	var instances []map[string]interface{}
+	switch v := attribute.(type) {
+	case []interface{}:
+		for _, instance := range v {
+			if instanceInterface, ok := instance.(map[string]interface{}); ok {
+				instances = append(instances, instanceInterface)
+			}
+		}
+	case map[string]interface{}:
+		instances = append(instances, v)
+	}
+	// Handle case where attribute value is string (id, type, @context...)
+	return instances
+}
+
+// Return the value as a float if it can be displayed on a graph, nil otherwise
+func numericValue(value interface{}) *float64 {
+	var result float64
+	switch v := value.(type) {
+	case float64:
+		result = v
+	case bool:
+		if v {
+			result = 1
+		}
+	default:
+		return nil
+	}
+	return &result
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+)
+
+func TestValidateAggrMethods(t *testing.T) {
+	tests := []struct {
+		aggrMethods string
+		wantErr     bool
+	}{
+		{aggrMethods: "sum"},
+		{aggrMethods: "sum,avg"},
+		{aggrMethods: "sum, avg , max"},
+		{aggrMethods: "sum,,avg,"},
+		{aggrMethods: "sum,average", wantErr: true},
+		{aggrMethods: "Sum", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.aggrMethods, func(t *testing.T) {
+			if err := validateAggrMethods(tt.aggrMethods); (err != nil) != tt.wantErr {
+				t.Errorf("validateAggrMethods(%q) error = %v, wantErr %v", tt.aggrMethods, err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestTransformAggregatedToTimeSeries(t *testing.T) {
+	entities := `[{"id":"urn:a","type":"Sensor","temperature":{"type":"Property",
+		"sum":[[10,"2022-01-01T00:00:00Z","2022-01-01T01:00:00Z"]],
+		"avg":[[5,"2022-01-01T00:00:00Z","2022-01-01T01:00:00Z"]]}}]`
+	response := transformAggregatedToTimeSeries(queryModel{AggrMethods: "sum, avg"}, []byte(entities), backend.DataResponse{})
+	if response.Error != nil {
+		t.Fatal(response.Error)
+	}
+	if len(response.Frames) != 1 || len(response.Frames[0].Fields) != 3 {
+		t.Fatalf("frames = %+v, want a time field and a field per method", response.Frames)
+	}
+	for i, name := range []string{"temperature sum", "temperature avg"} {
+		field := response.Frames[0].Fields[i+1]
+		if field.Name != name || field.At(0) == nil {
+			t.Errorf("field %d = %s with %v, want %s with a value", i+1, field.Name, field.At(0), name)
+		}
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend/log"
+)
+
+// The access token is renewed this long before it expires, so that it is still valid when the broker receives it
+const tokenExpiryMargin = 30 * time.Second
+
+// Validity assumed for an access token sent without its expiry, the default lifetime of the Keycloak access tokens
+const defaultTokenValidity = 5 * time.Minute
+
+// tokenManager keeps the access token of a datasource instance and renews it only when needed.
+// It is shared by all the queries of the instance, so it is safe for concurrent use.
+// It authenticates the requests with the OAuth2 grants : client_credentials or password.
+type tokenManager struct {
+	instSetting *instanceSettings
+	// Parameters of the grant used to get a new access token (grant_type, client_id, scope...)
+	grant url.Values
+
+	mu           sync.Mutex
+	accessToken  string
+	expiresAt    time.Time
+	refreshToken string
+	// Zero when the refresh token does not expire
+	refreshExpiresAt time.Time
+}
+
+func newTokenManager(instSetting *instanceSettings, grant url.Values) *tokenManager {
+	return &tokenManager{
+		instSetting: instSetting,
+		grant:       grant,
+	}
+}
+
+// Set the access token as a bearer token of the request
+func (tm *tokenManager) authenticate(ctx context.Context, r *http.Request) error {
+	token, err := tm.getAccessToken(ctx)
+	if err != nil {
+		return err
+	}
+	r.Header.Set("Authorization", "Bearer "+token)
+	return nil
+}
+
+// Forget the access token rejected by the broker, a new one will be asked for the next request
+func (tm *tokenManager) invalidate(r *http.Request) bool {
+	tm.invalidateToken(strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer "))
+	return true
+}
+
+// Return a valid access token, using the refresh token or a new grant if the current one expired
+func (tm *tokenManager) getAccessToken(ctx context.Context) (string, error) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	now := time.Now()
+	if tm.accessToken != "" && now.Before(tm.expiresAt) {
+		return tm.accessToken, nil
+	}
+
+	//Use the refresh token while it is valid, it avoids a new authentication of the client
+	if tm.refreshToken != "" && (tm.refreshExpiresAt.IsZero() || now.Before(tm.refreshExpiresAt)) {
+		data := url.Values{}
+		data.Set("client_id", tm.grant.Get("client_id"))
+		if clientSecret := tm.grant.Get("client_secret"); clientSecret != "" {
+			data.Set("client_secret", clientSecret)
+		}
+		data.Set("grant_type", "refresh_token")
+		data.Set("refresh_token", tm.refreshToken)
+		token, err := getToken(ctx, tm.instSetting, data)
+		if err == nil {
+			tm.store(token, now)
+			return tm.accessToken, nil
+		}
+		log.DefaultLogger.Debug("unable to refresh the access token", "err", err)
+	}
+
+	token, err := getToken(ctx, tm.instSetting, tm.grant)
+	if err != nil {
+		tm.accessToken = ""
+		tm.refreshToken = ""
+		return "", err
+	}
+	tm.store(token, now)
+	return tm.accessToken, nil
+}
+
+// Forget the access token if it is the one rejected by the broker, so that the next call gets a new one
+func (tm *tokenManager) invalidateToken(rejectedToken string) {
+	tm.mu.Lock()
+	defer tm.mu.Unlock()
+
+	if tm.accessToken == rejectedToken {
+		tm.accessToken = ""
+		tm.refreshToken = ""
+	}
+}
+
+// Keep the token received from the auth server.
+// An expiry of 0 is unknown : the access token gets the default validity, and the refresh token never expires
+// (Keycloak sends 0 for the offline tokens), the auth server rejects it when it is no longer valid.
+func (tm *tokenManager) store(token Token, receivedAt time.Time) {
+	tm.accessToken = token.Access_token
+	expiresIn := time.Duration(token.Expires_in) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = defaultTokenValidity
+	}
+	tm.expiresAt = expiryTime(receivedAt, expiresIn)
+	tm.refreshToken = token.Refresh_token
+	tm.refreshExpiresAt = time.Time{}
+	if token.Refresh_expires_in > 0 {
+		tm.refreshExpiresAt = expiryTime(receivedAt, time.Duration(token.Refresh_expires_in)*time.Second)
+	}
+}
+
+// Return the time at which a token valid for expiresIn must be renewed
+func expiryTime(receivedAt time.Time, expiresIn time.Duration) time.Time {
+	validity := expiresIn - tokenExpiryMargin
+	if validity < 0 {
+		validity = 0
+	}
+	return receivedAt.Add(validity)
+}
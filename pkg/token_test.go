@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenManagerStore(t *testing.T) {
+	receivedAt := time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		name                 string
+		token                Token
+		wantExpiresAt        time.Time
+		wantRefreshExpiresAt time.Time
+	}{
+		{
+			name:                 "expiries sent",
+			token:                Token{Access_token: "a", Expires_in: 300, Refresh_token: "r", Refresh_expires_in: 1800},
+			wantExpiresAt:        receivedAt.Add(300*time.Second - tokenExpiryMargin),
+			wantRefreshExpiresAt: receivedAt.Add(1800*time.Second - tokenExpiryMargin),
+		},
+		{
+			name:                 "unknown access token expiry",
+			token:                Token{Access_token: "a", Refresh_token: "r", Refresh_expires_in: 1800},
+			wantExpiresAt:        receivedAt.Add(defaultTokenValidity - tokenExpiryMargin),
+			wantRefreshExpiresAt: receivedAt.Add(1800*time.Second - tokenExpiryMargin),
+		},
+		{
+			name:          "offline refresh token",
+			token:         Token{Access_token: "a", Expires_in: 60, Refresh_token: "r"},
+			wantExpiresAt: receivedAt.Add(60*time.Second - tokenExpiryMargin),
+		},
+		{
+			name:          "validity shorter than the margin",
+			token:         Token{Access_token: "a", Expires_in: 10},
+			wantExpiresAt: receivedAt,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tm := &tokenManager{}
+			tm.store(tt.token, receivedAt)
+			if !tm.expiresAt.Equal(tt.wantExpiresAt) {
+				t.Errorf("expiresAt = %v, want %v", tm.expiresAt, tt.wantExpiresAt)
+			}
+			if !tm.refreshExpiresAt.Equal(tt.wantRefreshExpiresAt) {
+				t.Errorf("refreshExpiresAt = %v, want %v", tm.refreshExpiresAt, tt.wantRefreshExpiresAt)
+			}
+		})
+	}
+}
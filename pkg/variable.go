@@ -0,0 +1,105 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Values listed by a variable query
+var variableTypes = map[string]bool{
+	"ids":             true,
+	"types":           true,
+	"attributes":      true,
+	"attributeValues": true,
+	"scopes":          true,
+}
+
+// Variable type of the variable queries that do not tell it
+const defaultVariableType = "ids"
+
+// Check the variable type of a variable query and return it, the attribute is needed to list its values
+func validateVariableQuery(qm queryModel) (string, error) {
+	if qm.VariableType == "" {
+		return defaultVariableType, nil
+	}
+	if !variableTypes[qm.VariableType] {
+		return "", fmt.Errorf("unknown variable type %q, allowed types are ids, types, attributes, attributeValues and scopes", qm.VariableType)
+	}
+	if qm.VariableType == "attributeValues" && qm.MapMetric == "" {
+		return "", fmt.Errorf("an attribute is needed to list its values")
+	}
+	return qm.VariableType, nil
+}
+
+// Return a DataResponse to fill a dashboard variable
+// (The dataResponse contains a frame with a single field : the distinct values found in the entities, in alphabetical order)
+func transformToVariableValues(qm queryModel, entitiesByte []byte, response backend.DataResponse) backend.DataResponse {
+	var entities []map[string]interface{}
+	err := json.Unmarshal(entitiesByte, &entities)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	var found = map[string]bool{}
+	var values = []string{}
+	addValue := func(value string) {
+		if value != "" && !found[value] {
+			found[value] = true
+			values = append(values, value)
+		}
+	}
+
+	// Range over entities
+	for _, entity := range entities {
+		switch qm.VariableType {
+		case "ids":
+			entityId, _ := entity["id"].(string)
+			addValue(entityId)
+		case "types":
+			for _, entityType := range stringList(entity["type"]) {
+				addValue(entityType)
+			}
+		case "attributes":
+			for _, name := range attributeNames(entity) {
+				addValue(name)
+			}
+		case "attributeValues":
+			for _, instance := range attributeInstances(entity[qm.MapMetric]) {
+				addValue(variableValue(attributeValue(instance)))
+			}
+		case "scopes":
+			for _, scope := range stringList(entity["scope"]) {
+				addValue(scope)
+			}
+		}
+	}
+	sort.Strings(values)
+
+	frame := data.NewFrame(qm.VariableType,
+		data.NewField("values", nil, values),
+	)
+	response.Frames = append(response.Frames, frame)
+	return response
+}
+
+// Return the text of a value as it is used in a dashboard variable
+func variableValue(value interface{}) string {
+	switch v := value.(type) {
+	case nil:
+		return ""
+	case string:
+		return v
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64)
+	case bool:
+		return strconv.FormatBool(v)
+	}
+	jsonValue, _ := json.Marshal(value)
+	return string(jsonValue)
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"sort"
+
+	"github.com/grafana/grafana-plugin-sdk-go/backend"
+	"github.com/grafana/grafana-plugin-sdk-go/data"
+)
+
+// Members of an attribute instance that are not displayed in their own column
+var wideIgnoredMembers = map[string]bool{
+	"type":       true,
+	"unitCode":   true,
+	"datasetId":  true,
+	"instanceId": true,
+}
+
+// Members of an attribute instance holding a date-time
+var wideTimeMembers = map[string]bool{
+	"observedAt": true,
+	"createdAt":  true,
+	"modifiedAt": true,
+	"deletedAt":  true,
+}
+
+// Columns of the wide table, the columns of an attribute are followed by the columns of its sub-properties
+type wideColumns struct {
+	columns map[string]*valueColumn
+	// Attribute of each column and name of the sub-property, empty for the column of the attribute value
+	sortKeys map[string][2]string
+}
+
+// Return a DataResponse to display the entities side by side
+// (The dataResponse contains a frame with one row per entity : id, type, then one column per attribute
+// and one column per sub-property of the attributes, like temperature.observedAt)
+func transformToWide(qm queryModel, entitiesByte []byte, response backend.DataResponse) backend.DataResponse {
+	var entities []map[string]interface{}
+	err := json.Unmarshal(entitiesByte, &entities)
+	if err != nil {
+		response.Error = err
+		return response
+	}
+
+	var ids = make([]string, len(entities))
+	var types = make([]string, len(entities))
+	var scopes = make([]string, len(entities))
+	var hasScope = false
+	columns := &wideColumns{columns: map[string]*valueColumn{}, sortKeys: map[string][2]string{}}
+
+	// Range over entities, each entity is a row
+	for row, entity := range entities {
+		ids[row], _ = entity["id"].(string)
+		types[row] = listString(entity["type"])
+		scopes[row] = listString(entity["scope"])
+		hasScope = hasScope || scopes[row] != ""
+
+		// Range over attributes, each instance of an attribute has its own columns
+		for _, k := range attributeNames(entity) {
+			for _, instance := range attributeInstances(entity[k]) {
+				attributeColumn := k
+				if datasetId, _ := instance["datasetId"].(string); datasetId != "" {
+					attributeColumn = k + " (" + datasetId + ")"
+				}
+				columns.set(attributeColumn, "", row, attributeValue(instance), unitCode(instance))
+
+				for member, memberValue := range instance {
+					if wideIgnoredMembers[member] || isValueMember(member) {
+						continue
+					}
+					switch v := memberValue.(type) {
+					case map[string]interface{}:
+						//A property of the property, or a relationship of the property
+						columns.set(attributeColumn, member, row, attributeValue(v), unitCode(v))
+					case string:
+						if parsedTime := parseTime(v); wideTimeMembers[member] && parsedTime != nil {
+							columns.set(attributeColumn, member, row, *parsedTime, "")
+						} else {
+							columns.set(attributeColumn, member, row, v, "")
+						}
+					default:
+						columns.set(attributeColumn, member, row, v, "")
+					}
+				}
+			}
+		}
+	}
+
+	frame := data.NewFrame(qm.EntityId,
+		data.NewField("id", nil, ids),
+		data.NewField("type", nil, types),
+	)
+	if hasScope {
+		frame.Fields = append(frame.Fields, data.NewField("scope", nil, scopes))
+	}
+	for _, column := range columns.sorted() {
+		//Entities without the attribute have a null value
+		for column.len() < len(entities) {
+			column.append(nil, "")
+		}
+		frame.Fields = append(frame.Fields, column.fields()...)
+	}
+
+	response.Frames = append(response.Frames, frame)
+	return response
+}
+
+// Set the value of a row in the column of an attribute, or of one of its sub-properties
+func (w *wideColumns) set(attributeColumn string, member string, row int, value interface{}, unitCode string) {
+	name := attributeColumn
+	if member != "" {
+		name = attributeColumn + "." + member
+	}
+	column, found := w.columns[name]
+	if !found {
+		column = newValueColumn(name)
+		w.columns[name] = column
+		w.sortKeys[name] = [2]string{attributeColumn, member}
+	}
+	//Only the first value is kept when an entity has twice the same instance
+	if column.len() > row {
+		return
+	}
+	for column.len() < row {
+		column.append(nil, "")
+	}
+	column.append(value, unitCode)
+}
+
+// Return the columns ordered by attribute, the column of the attribute value first
+func (w *wideColumns) sorted() []*valueColumn {
+	names := make([]string, 0, len(w.columns))
+	for name := range w.columns {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		keyI, keyJ := w.sortKeys[names[i]], w.sortKeys[names[j]]
+		if keyI[0] != keyJ[0] {
+			return keyI[0] < keyJ[0]
+		}
+		return keyI[1] < keyJ[1]
+	})
+	columns := make([]*valueColumn, len(names))
+	for i, name := range names {
+		columns[i] = w.columns[name]
+	}
+	return columns
+}
+
+// Tell if a member of an attribute instance holds the value of the attribute
+func isValueMember(member string) bool {
+	for _, valueMember := range attributeValueMembers {
+		if member == valueMember {
+			return true
+		}
+	}
+	return false
+}
@@ -1,19 +1,89 @@
-import { DataSourceInstanceSettings } from '@grafana/data';
+import { DataQueryRequest, DataSourceInstanceSettings, MetricFindValue } from '@grafana/data';
 import { DataSourceWithBackend } from '@grafana/runtime';
-import { MyDataSourceOptions, MyQuery } from './types';
+import { EntitiesPage, MyDataSourceOptions, MyQuery, MyVariableQuery, QueryMode, VariableType } from './types';
 import { getTemplateSrv } from '@grafana/runtime';
 
 export class DataSource extends DataSourceWithBackend<MyQuery, MyDataSourceOptions> {
+  // Context used by the backend when the dashboard has no "context" variable
+  defaultContext: string;
+
   constructor(instanceSettings: DataSourceInstanceSettings<MyDataSourceOptions>) {
     super(instanceSettings);
+    this.defaultContext = instanceSettings.jsonData.context || '';
   }
   applyTemplateVariables(query: MyQuery) {
     const templateSrv = getTemplateSrv();
     return {
       ...query,
-      entityId: query.entityId ? templateSrv.replace(query.entityId) : '',
+      entityId: query.entityId ? templateSrv.replace(query.entityId, {}, 'csv') : '',
+      idPattern: query.idPattern ? templateSrv.replace(query.idPattern, {}, 'regex') : '',
+      entityType: query.entityType ? templateSrv.replace(query.entityType, {}, 'csv') : '',
+      valueFilterQuery: query.valueFilterQuery ? templateSrv.replace(query.valueFilterQuery) : '',
       attribute: query.attribute ? templateSrv.replace(query.attribute) : '',
       context: query.context ? templateSrv.replace(query.context) : '',
+      tenant: query.tenant ? templateSrv.replace(query.tenant) : '',
+      georel: query.georel ? templateSrv.replace(query.georel) : '',
+      coordinates: query.coordinates ? templateSrv.replace(query.coordinates) : '',
+      attrs: query.attrs ? templateSrv.replace(query.attrs, {}, 'csv') : '',
+      scopeQuery: query.scopeQuery ? templateSrv.replace(query.scopeQuery, {}, 'pipe') : '',
+      relationships: query.relationships ? templateSrv.replace(query.relationships, {}, 'csv') : '',
+    };
+  }
+
+  // Values of a dashboard variable, listed by the backend from the entities matching the variable query
+  metricFindQuery(query: MyVariableQuery, options?: any): Promise<MetricFindValue[]> {
+    const target: MyQuery = {
+      ...query,
+      refId: 'variable',
+      queryMode: QueryMode.Variable,
+      variableType: query.variableType || VariableType.Ids,
+      context: this.contextVariable(),
+    };
+    const request = { targets: [target], range: options?.range } as DataQueryRequest<MyQuery>;
+    return this.query(request)
+      .toPromise()
+      .then(response => {
+        //An empty list would hide why the variable has no values
+        if (response.error) {
+          throw new Error(response.error.message);
+        }
+        const frame = response.data[0];
+        if (!frame || frame.fields.length === 0) {
+          return [];
+        }
+        return frame.fields[0].values.toArray().map((value: string) => ({ text: value }));
+      });
+  }
+
+  // The JSON-LD context is given by the dashboard variable named "context"
+  contextVariable(): string {
+    const variable = getTemplateSrv()
+      .getVariables()
+      .find(variable => variable.name === 'context') as any;
+    return variable && variable.query ? variable.query : '';
+  }
+
+  // Entity types known by the broker
+  getEntityTypes(query: MyQuery): Promise<string[]> {
+    return this.getResource('types', this.resourceParams(query));
+  }
+
+  // Attributes of the entities of the query type, or all the attributes known by the broker
+  getAttributes(query: MyQuery): Promise<string[]> {
+    return this.getResource('attributes', { ...this.resourceParams(query), type: query.entityType });
+  }
+
+  // Page of the entities of the query type with ids starting with the prefix
+  getEntities(query: MyQuery, idPrefix?: string, offset?: number): Promise<EntitiesPage> {
+    return this.getResource('entities', { ...this.resourceParams(query), type: query.entityType, idPrefix, offset });
+  }
+
+  // The resources are asked with the context and the tenant of the query
+  resourceParams(query: MyQuery) {
+    const templateSrv = getTemplateSrv();
+    return {
+      context: query.context ? templateSrv.replace(query.context) : undefined,
+      tenant: query.tenant ? templateSrv.replace(query.tenant) : undefined,
     };
   }
 }
@@ -4,7 +4,7 @@ import React, { ChangeEvent, PureComponent, MouseEvent } from 'react';
 import { LegacyForms, Button, InlineFormLabel, Select } from '@grafana/ui';
 import { QueryEditorProps, SelectableValue } from '@grafana/data';
 import { DataSource } from './DataSource';
-import { defaultQuery, MyDataSourceOptions, MyQuery, PanelQueryFormat } from './types';
+import { defaultQuery, MyDataSourceOptions, MyQuery, PanelQueryFormat, QueryMode } from './types';
 import { getTemplateSrv } from '@grafana/runtime';
 import { VariableModel } from '@grafana/data/types/templateVars';
 interface QueryContext {
@@ -12,17 +12,54 @@ interface QueryContext {
   name: string;
 }
 
-const { FormField } = LegacyForms;
+const { FormField, Switch } = LegacyForms;
 
 const FORMAT_OPTIONS: Array<SelectableValue<PanelQueryFormat>> = [
   { label: 'Table', value: PanelQueryFormat.Table },
   { label: 'World Map', value: PanelQueryFormat.WorldMap },
+  { label: 'Wide table', value: PanelQueryFormat.Wide },
+  { label: 'Geomap', value: PanelQueryFormat.Geomap },
+  { label: 'Node Graph', value: PanelQueryFormat.NodeGraph },
+  { label: 'Alerting', value: PanelQueryFormat.Alerting },
 ];
-let isWorldMap = true;
+
+// Formats displaying the value of an attribute for each entity
+const METRIC_FORMATS = [PanelQueryFormat.WorldMap, PanelQueryFormat.Geomap, PanelQueryFormat.NodeGraph];
+
+const GEOMETRY_OPTIONS: Array<SelectableValue<string>> = [
+  { label: 'None', value: '' },
+  { label: 'Point', value: 'Point' },
+  { label: 'MultiPoint', value: 'MultiPoint' },
+  { label: 'LineString', value: 'LineString' },
+  { label: 'MultiLineString', value: 'MultiLineString' },
+  { label: 'Polygon', value: 'Polygon' },
+  { label: 'MultiPolygon', value: 'MultiPolygon' },
+];
+
+const QUERY_MODE_OPTIONS: Array<SelectableValue<QueryMode>> = [
+  { label: 'Entities', value: QueryMode.Entities },
+  { label: 'Temporal', value: QueryMode.Temporal },
+];
+let hasMetric = true;
 let variables = (getTemplateSrv().getVariables() as unknown) as Array<VariableModel & QueryContext>;
 
 type Props = QueryEditorProps<DataSource, MyQuery, MyDataSourceOptions>;
-export class QueryEditor extends PureComponent<Props> {
+
+// Values listed on the broker to complete the fields of the query
+interface State {
+  entityTypes: string[];
+  attributes: string[];
+  entityIds: string[];
+}
+
+export class QueryEditor extends PureComponent<Props, State> {
+  state: State = { entityTypes: [], attributes: [], entityIds: [] };
+
+  componentDidMount() {
+    this.loadEntityTypes();
+    this.loadEntityCompletions();
+  }
+
   componentDidUpdate() {
     let currentVariables = getTemplateSrv().getVariables();
     if (currentVariables && currentVariables !== variables) {
@@ -31,6 +68,27 @@ export class QueryEditor extends PureComponent<Props> {
     }
   }
 
+  //The completions are only a help, the query can still be typed when the broker can't list them
+  loadEntityTypes = () => {
+    const { datasource, query } = this.props;
+    datasource
+      .getEntityTypes(query)
+      .then(entityTypes => this.setState({ entityTypes }))
+      .catch(() => this.setState({ entityTypes: [] }));
+  };
+
+  loadEntityCompletions = () => {
+    const { datasource, query } = this.props;
+    datasource
+      .getAttributes(query)
+      .then(attributes => this.setState({ attributes }))
+      .catch(() => this.setState({ attributes: [] }));
+    datasource
+      .getEntities(query)
+      .then(page => this.setState({ entityIds: page.entities.map(entity => entity.id) }))
+      .catch(() => this.setState({ entityIds: [] }));
+  };
+
   onEntityIdChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query } = this.props;
     onChange({ ...query, entityId: event.target.value });
@@ -57,6 +115,76 @@ export class QueryEditor extends PureComponent<Props> {
     onChange({ ...query, valueFilterQuery: event.target.value });
   };
 
+  onTenantChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, tenant: event.target.value });
+  };
+
+  onGeorelChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, georel: event.target.value });
+  };
+
+  onGeometryChange = (option: SelectableValue<string>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, geometry: option.value });
+  };
+
+  onCoordinatesChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, coordinates: event.target.value });
+  };
+
+  onGeopropertyChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, geoproperty: event.target.value });
+  };
+
+  onDisplayGeopropertyChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, displayGeoproperty: event.target.value });
+  };
+
+  onAttrsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, attrs: event.target.value });
+  };
+
+  onPickChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, pick: event.target.value });
+  };
+
+  onOmitChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, omit: event.target.value });
+  };
+
+  onIdPatternChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, idPattern: event.target.value });
+  };
+
+  onScopeQueryChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, scopeQuery: event.target.value });
+  };
+
+  onRelationshipsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, relationships: event.target.value });
+  };
+
+  onJoinLevelChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, joinLevel: parseInt(event.target.value, 10) || undefined });
+  };
+
+  onLiveChange = (event: React.SyntheticEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, live: event.currentTarget.checked });
+  };
+
   onMetadataSelectorChange = (event: ChangeEvent<HTMLInputElement>) => {
     const { onChange, query } = this.props;
     onChange({ ...query, metadataSelector: event.target.value });
@@ -70,10 +198,36 @@ export class QueryEditor extends PureComponent<Props> {
     const { query, onChange } = this.props;
     if (option.value) {
       onChange({ ...query, format: option.value });
-      isWorldMap = option.value === 'worldmap';
+      hasMetric = METRIC_FORMATS.includes(option.value);
+    }
+  };
+
+  getQueryModeOption = () => {
+    return QUERY_MODE_OPTIONS.find(v => v.value === (this.props.query.queryMode || QueryMode.Entities));
+  };
+
+  onQueryModeChange = (option: SelectableValue<QueryMode>) => {
+    const { query, onChange } = this.props;
+    if (option.value) {
+      onChange({ ...query, queryMode: option.value });
     }
   };
 
+  onTimePropertyChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, timeProperty: event.target.value });
+  };
+
+  onAggrMethodsChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, aggrMethods: event.target.value });
+  };
+
+  onAggrPeriodDurationChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { onChange, query } = this.props;
+    onChange({ ...query, aggrPeriodDuration: event.target.value });
+  };
+
   //Check if a variable named 'context' exists
   isContextSet(currentVariables: QueryContext[]) {
     let found = false;
@@ -86,22 +240,83 @@ export class QueryEditor extends PureComponent<Props> {
     });
     if (!found) {
       this.props.query.context = '';
-      throw new Error('Create a dashboard variable named "context" with your context');
+      //The backend uses the default context of the datasource
+      if (!this.props.datasource.defaultContext) {
+        throw new Error('Create a dashboard variable named "context" with your context, or give a default context in the datasource settings');
+      }
     }
   }
 
   render() {
     const query = defaults(this.props.query, defaultQuery);
-    const { entityId, entityType, valueFilterQuery, metadataSelector } = query;
+    const { entityId, entityType, valueFilterQuery, metadataSelector, timeProperty } = query;
+    const { aggrMethods, aggrPeriodDuration, tenant, georel, geometry, coordinates, geoproperty } = query;
+    const { attrs, pick, omit, scopeQuery, idPattern, relationships, joinLevel, live, displayGeoproperty } = query;
+    const isTemporal = query.queryMode === QueryMode.Temporal;
+    const isMap = query.format === PanelQueryFormat.WorldMap || query.format === PanelQueryFormat.Geomap;
 
     return (
       <div>
+        <div className="gf-form-inline">
+          <InlineFormLabel width={11}>Query mode</InlineFormLabel>
+          <Select
+            isSearchable={false}
+            width={20}
+            options={QUERY_MODE_OPTIONS}
+            onChange={this.onQueryModeChange}
+            value={this.getQueryModeOption()}
+          />
+          {isTemporal && (
+            <FormField
+              labelWidth={11}
+              inputWidth={20}
+              value={timeProperty || ''}
+              onChange={this.onTimePropertyChange}
+              tooltip="The temporal property used to order the values (observedAt, createdAt or modifiedAt)"
+              placeholder="observedAt"
+              label="Time property"
+            />
+          )}
+          {!isTemporal && (
+            <Switch
+              label="Live"
+              labelClass="width-6"
+              checked={live || false}
+              onChange={this.onLiveChange}
+              tooltip="Receive the changes of the entities as soon as the broker notifies them (an entity type is needed), or at each poll interval when the datasource has no notification URL"
+            />
+          )}
+        </div>
+        {isTemporal && (
+          <div className="gf-form-inline">
+            <FormField
+              labelWidth={11}
+              inputWidth={20}
+              value={aggrMethods || ''}
+              onChange={this.onAggrMethodsChange}
+              tooltip="Comma separated list of aggregation methods computed by the broker (avg, min, max, sum, totalCount...)"
+              placeholder="avg,min,max"
+              label="Aggregation methods"
+            />
+            <FormField
+              labelWidth={11}
+              inputWidth={20}
+              value={aggrPeriodDuration || ''}
+              onChange={this.onAggrPeriodDurationChange}
+              tooltip="ISO 8601 duration of the aggregation periods"
+              placeholder="PT1H"
+              label="Aggregation period"
+            />
+          </div>
+        )}
         <div className="gf-form-inline">
           <FormField
             labelWidth={11}
             inputWidth={20}
             value={entityId || ''}
             onChange={this.onEntityIdChange}
+            list={`${query.refId}-entity-ids`}
+            tooltip="One or several comma separated entity ids"
             label="Entity Identifier"
             placeholder="urn:ngsi-ld: ..."
           />
@@ -120,6 +335,10 @@ export class QueryEditor extends PureComponent<Props> {
             inputWidth={20}
             value={entityType || ''}
             onChange={this.onEntityTypeChange}
+            onBlur={this.loadEntityCompletions}
+            list={`${query.refId}-entity-types`}
+            tooltip="One or several comma separated types, or a type expression combining types with ; (and) and | (or)"
+            placeholder="Building,Room"
             label="Entity Type"
           />
           <FormField
@@ -132,13 +351,136 @@ export class QueryEditor extends PureComponent<Props> {
             label="Value Filter Query"
           />
         </div>
-        {isWorldMap && (
+        <div className="gf-form-inline">
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={idPattern || ''}
+            onChange={this.onIdPatternChange}
+            tooltip="Regular expression matching the ids of the entities"
+            placeholder="urn:ngsi-ld:Sensor:.*"
+            label="Id pattern"
+          />
+        </div>
+        <div className="gf-form-inline">
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={attrs || ''}
+            onChange={this.onAttrsChange}
+            list={`${query.refId}-attributes`}
+            tooltip="Comma separated list of the attributes to get, all attributes are returned when empty"
+            placeholder="temperature,humidity"
+            label="Attributes"
+          />
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={scopeQuery || ''}
+            onChange={this.onScopeQueryChange}
+            tooltip="Scopes of the entities, combined with ; (and) and | (or). + matches one level and # all the levels below"
+            placeholder="/France/Grenoble/#"
+            label="Scope"
+          />
+        </div>
+        <div className="gf-form-inline">
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={pick || ''}
+            onChange={this.onPickChange}
+            tooltip="Comma separated list of the entity members to keep (NGSI-LD 1.8)"
+            label="Pick"
+          />
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={omit || ''}
+            onChange={this.onOmitChange}
+            tooltip="Comma separated list of the entity members to remove (NGSI-LD 1.8)"
+            label="Omit"
+          />
+        </div>
+        <div className="gf-form-inline">
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={relationships || ''}
+            onChange={this.onRelationshipsChange}
+            tooltip="Comma separated list of the relationships to follow, the attributes of the linked entities are added as refBuilding.name"
+            placeholder="refRoom,refBuilding"
+            label="Relationships"
+          />
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            type="number"
+            value={joinLevel || ''}
+            onChange={this.onJoinLevelChange}
+            tooltip="Number of levels of relationships to follow, from 1 to 5"
+            placeholder="1"
+            label="Join level"
+          />
+        </div>
+        <div className="gf-form-inline">
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={georel || ''}
+            onChange={this.onGeorelChange}
+            tooltip="Geo relationship of the geo-query : near;maxDistance==2000, within, contains, intersects..."
+            placeholder="within"
+            label="Georel"
+          />
+          <InlineFormLabel width={11}>Geometry</InlineFormLabel>
+          <Select
+            isSearchable={false}
+            width={20}
+            options={GEOMETRY_OPTIONS}
+            onChange={this.onGeometryChange}
+            value={GEOMETRY_OPTIONS.find(v => v.value === (geometry || ''))}
+          />
+        </div>
+        <div className="gf-form-inline">
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={coordinates || ''}
+            onChange={this.onCoordinatesChange}
+            tooltip="GeoJSON coordinates of the geometry"
+            placeholder="[5.72,45.18]"
+            label="Coordinates"
+          />
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={geoproperty || ''}
+            onChange={this.onGeopropertyChange}
+            tooltip="GeoProperty used by the geo-query, location by default"
+            placeholder="location"
+            label="Geoproperty"
+          />
+        </div>
+        {(hasMetric || isTemporal) && (
           <FormField
             labelWidth={11}
             inputWidth={20}
             label="Attribute to use as a metric"
             value={query.attribute || ''}
             onChange={this.onAttributeChange}
+            list={`${query.refId}-attributes`}
+          />
+        )}
+        {isMap && !isTemporal && (
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={displayGeoproperty || ''}
+            onChange={this.onDisplayGeopropertyChange}
+            tooltip="GeoProperty displayed on the map, the geoproperty of the geo-query or location by default"
+            placeholder={geoproperty || 'location'}
+            label="Displayed geoproperty"
+            list={`${query.refId}-attributes`}
           />
         )}
         <div className="gf-form-inline">
@@ -149,10 +491,34 @@ export class QueryEditor extends PureComponent<Props> {
             onChange={this.onMetadataSelectorChange}
             label="Metadata Selector"
           />
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={tenant || ''}
+            onChange={this.onTenantChange}
+            tooltip="Tenant of the entities, the default tenant of the datasource is used when empty"
+            placeholder="$tenant"
+            label="Tenant"
+          />
         </div>
         <Button size="md" variant="secondary" onClick={this.onConfirm}>
           Confirm
         </Button>
+        <datalist id={`${query.refId}-entity-types`}>
+          {this.state.entityTypes.map(entityType => (
+            <option key={entityType} value={entityType} />
+          ))}
+        </datalist>
+        <datalist id={`${query.refId}-entity-ids`}>
+          {this.state.entityIds.map(id => (
+            <option key={id} value={id} />
+          ))}
+        </datalist>
+        <datalist id={`${query.refId}-attributes`}>
+          {this.state.attributes.map(attribute => (
+            <option key={attribute} value={attribute} />
+          ))}
+        </datalist>
       </div>
     );
   }
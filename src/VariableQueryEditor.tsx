@@ -0,0 +1,139 @@
+import React, { ChangeEvent, PureComponent } from 'react';
+import { LegacyForms, InlineFormLabel, Select } from '@grafana/ui';
+import { SelectableValue } from '@grafana/data';
+import { MyVariableQuery, VariableType } from './types';
+
+const { FormField } = LegacyForms;
+
+const VARIABLE_TYPE_OPTIONS: Array<SelectableValue<VariableType>> = [
+  { label: 'Entity ids', value: VariableType.Ids },
+  { label: 'Entity types', value: VariableType.Types },
+  { label: 'Attribute names', value: VariableType.Attributes },
+  { label: 'Attribute values', value: VariableType.AttributeValues },
+  { label: 'Scopes', value: VariableType.Scopes },
+];
+
+interface Props {
+  query: MyVariableQuery;
+  onChange: (query: MyVariableQuery, definition: string) => void;
+}
+
+export class VariableQueryEditor extends PureComponent<Props> {
+  // The definition is the text displayed in the list of variables of the dashboard
+  onQueryChange = (query: MyVariableQuery) => {
+    const { onChange } = this.props;
+    let definition = `${query.variableType || VariableType.Ids} of ${query.entityType || 'all types'}`;
+    if (query.variableType === VariableType.AttributeValues) {
+      definition = `values of ${query.attribute || ''} of ${query.entityType || 'all types'}`;
+    }
+    if (query.valueFilterQuery) {
+      definition += ` where ${query.valueFilterQuery}`;
+    }
+    onChange(query, definition);
+  };
+
+  getVariableTypeOption = () => {
+    return VARIABLE_TYPE_OPTIONS.find(v => v.value === (this.props.query.variableType || VariableType.Ids));
+  };
+
+  onVariableTypeChange = (option: SelectableValue<VariableType>) => {
+    const { query } = this.props;
+    if (option.value) {
+      this.onQueryChange({ ...query, variableType: option.value });
+    }
+  };
+
+  onEntityTypeChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { query } = this.props;
+    this.onQueryChange({ ...query, entityType: event.target.value });
+  };
+
+  onValueFilterQueryChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { query } = this.props;
+    this.onQueryChange({ ...query, valueFilterQuery: event.target.value });
+  };
+
+  onAttributeChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { query } = this.props;
+    this.onQueryChange({ ...query, attribute: event.target.value });
+  };
+
+  onScopeQueryChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { query } = this.props;
+    this.onQueryChange({ ...query, scopeQuery: event.target.value });
+  };
+
+  onTenantChange = (event: ChangeEvent<HTMLInputElement>) => {
+    const { query } = this.props;
+    this.onQueryChange({ ...query, tenant: event.target.value });
+  };
+
+  render() {
+    const { entityType, valueFilterQuery, attribute, scopeQuery, tenant } = this.props.query;
+    const isAttributeValues = this.props.query.variableType === VariableType.AttributeValues;
+
+    return (
+      <div>
+        <div className="gf-form-inline">
+          <InlineFormLabel width={11}>Values</InlineFormLabel>
+          <Select
+            isSearchable={false}
+            width={20}
+            options={VARIABLE_TYPE_OPTIONS}
+            onChange={this.onVariableTypeChange}
+            value={this.getVariableTypeOption()}
+          />
+          {isAttributeValues && (
+            <FormField
+              labelWidth={11}
+              inputWidth={20}
+              value={attribute || ''}
+              onChange={this.onAttributeChange}
+              placeholder="district"
+              label="Attribute"
+            />
+          )}
+        </div>
+        <div className="gf-form-inline">
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={entityType || ''}
+            onChange={this.onEntityTypeChange}
+            tooltip="One or several comma separated types, or a type expression combining types with ; (and) and | (or)"
+            placeholder="Sensor"
+            label="Entity Type"
+          />
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={valueFilterQuery || ''}
+            onChange={this.onValueFilterQueryChange}
+            tooltip="An expression conform to the NGSI-LD query language"
+            placeholder='status=="active"'
+            label="Value Filter Query"
+          />
+        </div>
+        <div className="gf-form-inline">
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={scopeQuery || ''}
+            onChange={this.onScopeQueryChange}
+            tooltip="Scopes of the entities, combined with ; (and) and | (or). + matches one level and # all the levels below"
+            placeholder="/France/Grenoble/#"
+            label="Scope"
+          />
+          <FormField
+            labelWidth={11}
+            inputWidth={20}
+            value={tenant || ''}
+            onChange={this.onTenantChange}
+            tooltip="Tenant of the entities, the default tenant of the datasource is used when empty"
+            label="Tenant"
+          />
+        </div>
+      </div>
+    );
+  }
+}
@@ -2,16 +2,44 @@ import { DataQuery, DataSourceJsonData } from '@grafana/data';
 
 export interface MyQuery extends DataQuery {
   entityId?: string;
+  idPattern?: string;
   format?: string;
   attribute?: string;
   context?: string;
   entityType?: string;
   valueFilterQuery?: string;
   metadataSelector?: string;
+  queryMode?: string;
+  timeProperty?: string;
+  aggrMethods?: string;
+  aggrPeriodDuration?: string;
+  tenant?: string;
+  georel?: string;
+  geometry?: string;
+  coordinates?: string;
+  geoproperty?: string;
+  displayGeoproperty?: string;
+  attrs?: string;
+  pick?: string;
+  omit?: string;
+  scopeQuery?: string;
+  relationships?: string;
+  joinLevel?: number;
+  variableType?: VariableType;
+  live?: boolean;
 }
 
 export const defaultQuery: Partial<MyQuery> = {};
 
+/**
+ * Page of entities listed by the entities resource of the backend
+ */
+export interface EntitiesPage {
+  entities: Array<{ id: string; type: string }>;
+  resultsCount?: number;
+  nextOffset?: number;
+}
+
 /**
  * These are options configured for each DataSource instance
  */
@@ -20,6 +48,25 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
   resource?: string;
   clientId?: string;
   contextBrokerUrl?: string;
+  pageSize?: number;
+  maxEntities?: number;
+  maxConcurrentQueries?: number;
+  timeout?: number;
+  tlsSkipVerify?: boolean;
+  tlsAuth?: boolean;
+  tlsAuthWithCACert?: boolean;
+  serverName?: string;
+  authMode?: AuthMode;
+  scope?: string;
+  audience?: string;
+  username?: string;
+  apiKeyHeader?: string;
+  tenant?: string;
+  apiVersion?: string;
+  notificationUrl?: string;
+  listenAddress?: string;
+  pollInterval?: number;
+  context?: string;
 }
 
 /**
@@ -27,9 +74,55 @@ export interface MyDataSourceOptions extends DataSourceJsonData {
  */
 export interface MySecureJsonData {
   clientSecret?: string;
+  tlsCACert?: string;
+  tlsClientCert?: string;
+  tlsClientKey?: string;
+  bearerToken?: string;
+  basicAuthPassword?: string;
+  apiKey?: string;
+  password?: string;
 }
 
 export enum PanelQueryFormat {
   Table = 'table',
   WorldMap = 'worldmap',
+  Wide = 'wide',
+  Geomap = 'geomap',
+  NodeGraph = 'nodegraph',
+  Alerting = 'alerting',
+}
+
+export enum QueryMode {
+  Entities = 'entities',
+  Temporal = 'temporal',
+  Variable = 'variable',
+}
+
+export enum AuthMode {
+  None = 'none',
+  Bearer = 'bearer',
+  Basic = 'basic',
+  ApiKey = 'apiKey',
+  Password = 'password',
+  ClientCredentials = 'clientCredentials',
+}
+
+export enum VariableType {
+  Ids = 'ids',
+  Types = 'types',
+  Attributes = 'attributes',
+  AttributeValues = 'attributeValues',
+  Scopes = 'scopes',
+}
+
+/**
+ * Query of a dashboard variable, the values are listed by the backend from the entities
+ */
+export interface MyVariableQuery {
+  variableType?: VariableType;
+  entityType?: string;
+  valueFilterQuery?: string;
+  attribute?: string;
+  scopeQuery?: string;
+  tenant?: string;
 }